@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"app/fig1"
+	"app/installs"
+)
+
+// requireAdmin only lets through requests with `Authorization: Bearer <admin_token>`.
+// Admin endpoints don't exist at all unless an `admin_token` is configured.
+func (app *SlackApp) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if app.AdminToken == "" {
+			http.Error(res, "Not found", http.StatusNotFound)
+			return
+		}
+
+		header := req.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if !strings.HasPrefix(header, "Bearer ") || subtle.ConstantTimeCompare([]byte(token), []byte(app.AdminToken)) != 1 {
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+func writeJSON(res http.ResponseWriter, status int, body interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(body); err != nil {
+		logger.Error("Failed to encode json response", "err", err)
+	}
+}
+
+// statusHandler reports anything an admin might need to know about, such as figure 1 being unreachable
+func (app *SlackApp) statusHandler(res http.ResponseWriter, req *http.Request) {
+	status := struct {
+		Figure1Auth authStatus `json:"figure1_auth"`
+		// workspaces with their own figure 1 account
+		Figure1Tenants map[string]authStatus `json:"figure1_tenants,omitempty"`
+	}{
+		Figure1Auth: app.auth.status(),
+	}
+	if app.tenants != nil {
+		status.Figure1Tenants = app.tenants.status()
+	}
+
+	code := http.StatusOK
+	if !status.Figure1Auth.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(res, code, status)
+}
+
+// purgeCacheHandler removes a single cached figure 1 response, eg: `DELETE /admin/cache?key=case:<id>`
+func (app *SlackApp) purgeCacheHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := req.URL.Query().Get("key")
+	if key == "" {
+		http.Error(res, "Missing key", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := app.cache.Get(key); !ok {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return
+	}
+	if err := app.cache.Delete(key); err != nil {
+		loggerFrom(req.Context()).Error("Failed to purge cache entry", "key", key, "err", err)
+		http.Error(res, "Failed to purge key", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(res, http.StatusOK, map[string]string{"purged": key})
+}
+
+// deadLettersHandler lists response_url deliveries that failed, eg: `GET /admin/dead-letters`,
+// or discards one, eg: `DELETE /admin/dead-letters?id=<id>`
+func (app *SlackApp) deadLettersHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(res, http.StatusOK, map[string]interface{}{"dead_letters": deadLetters.list()})
+	case http.MethodDelete:
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			http.Error(res, "Missing id", http.StatusBadRequest)
+			return
+		}
+		if !deadLetters.remove(id) {
+			http.Error(res, "Not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(res, http.StatusOK, map[string]string{"discarded": id})
+	default:
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// replayDeadLetterHandler tries to deliver a dead letter again, eg: `POST /admin/dead-letters/replay?id=<id>`
+func (app *SlackApp) replayDeadLetterHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := req.URL.Query().Get("id")
+	if id == "" {
+		http.Error(res, "Missing id", http.StatusBadRequest)
+		return
+	}
+	letter, ok := deadLetters.get(id)
+	if !ok {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch err := deadLetters.replay(req.Context(), letter, app.postToChannel); err {
+	case nil:
+		writeJSON(res, http.StatusOK, map[string]string{"replayed": id})
+	case errResponseURLExpired:
+		http.Error(res, "The response_url has expired and the message can't be posted to the channel instead", http.StatusGone)
+	default:
+		loggerFrom(req.Context()).Warn("Failed to replay dead letter", "dead_letter", id, "err", err)
+		http.Error(res, "Failed to deliver: "+err.Error(), http.StatusBadGateway)
+	}
+}
+
+// installationsHandler lists the workspaces that installed the app with oauth, without
+// their tokens, eg: `GET /admin/installations`
+func (app *SlackApp) installationsHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if app.installs == nil {
+		http.Error(res, "Installing with oauth isn't enabled", http.StatusNotFound)
+		return
+	}
+	writeJSON(res, http.StatusOK, map[string]interface{}{"installations": app.installs.List()})
+}
+
+// figure1CredentialsHandler manages the figure 1 accounts of workspaces that don't use the default one:
+//   - `GET /admin/figure1-credentials` lists them, without passwords
+//   - `PUT /admin/figure1-credentials?team=<team id>` with `{"email": "...", "password": "..."}` sets or rotates them
+//   - `DELETE /admin/figure1-credentials?team=<team id>` switches back to the default account
+func (app *SlackApp) figure1CredentialsHandler(res http.ResponseWriter, req *http.Request) {
+	if app.tenants == nil {
+		http.Error(res, "Workspace credentials need an encryption_key", http.StatusNotFound)
+		return
+	}
+
+	type credentialsStatus struct {
+		installs.Credentials
+		Auth authStatus `json:"auth"`
+	}
+
+	teamID := req.URL.Query().Get("team")
+	switch req.Method {
+	case http.MethodGet:
+		auth := app.tenants.status()
+		list := []credentialsStatus{}
+		for _, creds := range app.tenants.store.List() {
+			list = append(list, credentialsStatus{creds, auth[creds.TeamID]})
+		}
+		writeJSON(res, http.StatusOK, map[string]interface{}{"credentials": list})
+	case http.MethodPut:
+		var body struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || teamID == "" || body.Email == "" || body.Password == "" {
+			http.Error(res, "Expected a team and a json body with an email and password", http.StatusBadRequest)
+			return
+		}
+
+		creds := installs.Credentials{TeamID: teamID, Email: body.Email, Password: body.Password, UpdatedAt: time.Now()}
+		err := app.setFigure1Credentials(req.Context(), creds)
+		switch {
+		case err == nil:
+			loggerFrom(req.Context()).Info("Set figure 1 credentials", "team", teamID, "email", body.Email)
+			writeJSON(res, http.StatusOK, map[string]string{"team": teamID, "email": body.Email})
+		case fig1.IsUnauthorized(err):
+			http.Error(res, "Figure 1 rejected the credentials", http.StatusBadRequest)
+		case fig1.IsLoginError(err):
+			http.Error(res, "Failed to log in to Figure 1: "+err.Error(), http.StatusBadGateway)
+		default:
+			loggerFrom(req.Context()).Error("Failed to save figure 1 credentials", "team", teamID, "err", err)
+			http.Error(res, "Failed to save credentials", http.StatusInternalServerError)
+		}
+	case http.MethodDelete:
+		if teamID == "" {
+			http.Error(res, "Missing team", http.StatusBadRequest)
+			return
+		}
+		removed, err := app.removeFigure1Credentials(teamID)
+		if err != nil {
+			loggerFrom(req.Context()).Error("Failed to remove figure 1 credentials", "team", teamID, "err", err)
+			http.Error(res, "Failed to remove credentials", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(res, "Not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(res, http.StatusOK, map[string]string{"removed": teamID})
+	default:
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	tests := []struct {
+		token  string
+		header string
+		status int
+	}{
+		{"secret", "Bearer secret", 200},
+		{"secret", "secret", 401},
+		{"secret", "Basic secret", 401},
+		{"secret", "Bearer wrong", 401},
+		{"secret", "", 401},
+		{"", "Bearer ", 404},
+	}
+	for _, test := range tests {
+		app := &SlackApp{AdminToken: test.token}
+		handler := app.requireAdmin(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}))
+		req := httptest.NewRequest("GET", "/admin/status", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("Expected %q to get %v, got %v", test.header, test.status, res.Code)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"app/logging"
+)
+
+const (
+	authRetryMin = time.Second
+	authRetryMax = 5 * time.Minute
+)
+
+// authMonitor tracks whether we can log in to figure 1. After a failed login
+// it keeps retrying in the background with exponential backoff, so the app can
+// keep serving (and explaining the problem) instead of exiting.
+type authMonitor struct {
+	login      func(ctx context.Context) error
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	log        *logging.Logger
+
+	mu        sync.Mutex
+	healthy   bool
+	lastErr   error
+	since     time.Time
+	retrying  bool
+	nextRetry time.Time
+	stopped   bool
+}
+
+// authStatus is a snapshot of the figure 1 login state
+type authStatus struct {
+	Healthy   bool       `json:"healthy"`
+	Error     string     `json:"error,omitempty"`
+	Since     time.Time  `json:"since"`
+	NextRetry *time.Time `json:"next_retry,omitempty"`
+}
+
+func newAuthMonitor(login func(ctx context.Context) error) *authMonitor {
+	return &authMonitor{
+		login:      login,
+		minBackoff: authRetryMin,
+		maxBackoff: authRetryMax,
+		log:        logger,
+	}
+}
+
+// observe is called with the result of every login, including the ones
+// triggered by expired tokens mid-request
+func (m *authMonitor) observe(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		if !m.healthy {
+			m.log.Info("Figure 1 login succeeded")
+			m.since = time.Now()
+		}
+		m.healthy = true
+		m.lastErr = nil
+		return
+	}
+
+	if m.healthy || m.since.IsZero() {
+		m.since = time.Now()
+	}
+	m.healthy = false
+	m.lastErr = err
+	m.log.Error("Figure 1 login failed", "err", err)
+
+	if !m.retrying && !m.stopped {
+		m.retrying = true
+		go m.retry()
+	}
+}
+
+func (m *authMonitor) retry() {
+	backoff := m.minBackoff
+	for {
+		m.mu.Lock()
+		m.nextRetry = time.Now().Add(backoff)
+		m.mu.Unlock()
+		time.Sleep(backoff)
+
+		m.mu.Lock()
+		stopped := m.stopped
+		m.mu.Unlock()
+		var err error
+		if !stopped {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err = m.login(ctx)
+			cancel()
+		}
+
+		m.mu.Lock()
+		// a request might have logged in successfully in the meantime
+		if err == nil || m.healthy || m.stopped {
+			m.retrying = false
+			m.nextRetry = time.Time{}
+			m.mu.Unlock()
+			return
+		}
+		m.mu.Unlock()
+
+		backoff *= 2
+		if backoff > m.maxBackoff {
+			backoff = m.maxBackoff
+		}
+	}
+}
+
+// stop gives up retrying, eg: once a workspace's credentials have been replaced
+func (m *authMonitor) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+}
+
+func (m *authMonitor) status() authStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := authStatus{Healthy: m.healthy, Since: m.since}
+	if m.lastErr != nil {
+		status.Error = m.lastErr.Error()
+	}
+	if !m.healthy && m.retrying && !m.nextRetry.IsZero() {
+		next := m.nextRetry
+		status.NextRetry = &next
+	}
+	return status
+}
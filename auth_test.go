@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuthMonitorRetries(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+
+	var m *authMonitor
+	m = newAuthMonitor(func(ctx context.Context) error {
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+
+		// figure 1 recovers on the third attempt
+		var err error
+		if n < 3 {
+			err = errors.New("503")
+		}
+		m.observe(err)
+		return err
+	})
+	m.minBackoff = time.Millisecond
+	m.maxBackoff = 5 * time.Millisecond
+
+	m.observe(errors.New("503"))
+	if status := m.status(); status.Healthy || status.Error == "" {
+		t.Errorf("Expected degraded status after a failed login, got %+v", status)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !m.status().Healthy && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	status := m.status()
+	if !status.Healthy || status.NextRetry != nil {
+		t.Errorf("Expected background retries to recover, got %+v", status)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %v", attempts)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"app/fig1"
+)
+
+const (
+	blockSection = "section"
+	blockContext = "context"
+	blockActions = "actions"
+	blockDivider = "divider"
+
+	elementImage  = "image"
+	elementButton = "button"
+
+	textPlain    = "plain_text"
+	textMarkdown = "mrkdwn"
+
+	// noCaption stands in for an empty caption, slack rejects sections without text
+	noCaption = "No caption"
+)
+
+// Block is a Block Kit layout block, see https://api.slack.com/reference/block-kit/blocks
+type Block interface {
+	blockType() string
+}
+
+// BlockElement is anything that can go in a block's elements or accessory
+type BlockElement interface {
+	elementType() string
+}
+
+// TextObject is plain or markdown text, used inside blocks and as a context element
+type TextObject struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+// SectionBlock is text with optional fields and an accessory to the right
+type SectionBlock struct {
+	Type      string        `json:"type"`
+	BlockID   string        `json:"block_id,omitempty"`
+	Text      *TextObject   `json:"text,omitempty"`
+	Fields    []*TextObject `json:"fields,omitempty"`
+	Accessory BlockElement  `json:"accessory,omitempty"`
+}
+
+// ContextBlock is a line of small text and images
+type ContextBlock struct {
+	Type     string         `json:"type"`
+	BlockID  string         `json:"block_id,omitempty"`
+	Elements []BlockElement `json:"elements"`
+}
+
+// ActionsBlock holds interactive elements such as buttons
+type ActionsBlock struct {
+	Type     string         `json:"type"`
+	BlockID  string         `json:"block_id,omitempty"`
+	Elements []BlockElement `json:"elements"`
+}
+
+// DividerBlock is a horizontal rule
+type DividerBlock struct {
+	Type    string `json:"type"`
+	BlockID string `json:"block_id,omitempty"`
+}
+
+// ImageElement is a small image, used as a section accessory or in context blocks
+type ImageElement struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+// ButtonElement is a button inside an actions block or section accessory
+type ButtonElement struct {
+	Type     string      `json:"type"`
+	Text     *TextObject `json:"text"`
+	ActionID string      `json:"action_id,omitempty"`
+	Value    string      `json:"value,omitempty"`
+	URL      string      `json:"url,omitempty"`
+	Style    string      `json:"style,omitempty"`
+}
+
+func (b *SectionBlock) blockType() string { return b.Type }
+func (b *ContextBlock) blockType() string { return b.Type }
+func (b *ActionsBlock) blockType() string { return b.Type }
+func (b *DividerBlock) blockType() string { return b.Type }
+
+func (e *TextObject) elementType() string    { return e.Type }
+func (e *ImageElement) elementType() string  { return e.Type }
+func (e *ButtonElement) elementType() string { return e.Type }
+
+func plainText(text string) *TextObject {
+	return &TextObject{Type: textPlain, Text: text, Emoji: true}
+}
+
+func markdownText(text string) *TextObject {
+	return &TextObject{Type: textMarkdown, Text: text}
+}
+
+// mrkdwnEscaper escapes the characters slack uses for links and mentions, see
+// https://api.slack.com/reference/surfaces/formatting#escaping
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeMrkdwn makes figure 1 content safe to put in mrkdwn, so it can't break
+// links or mention `@channel`
+func escapeMrkdwn(text string) string {
+	return mrkdwnEscaper.Replace(text)
+}
+
+// mrkdwnLink is a `<url|label>` link, a `|` in the url would end it early
+func mrkdwnLink(link, label string) string {
+	return "<" + strings.Replace(escapeMrkdwn(link), "|", "%7C", -1) + "|" + escapeMrkdwn(label) + ">"
+}
+
+// captionText is a case's caption, or noCaption if it doesn't have one
+func captionText(caption string) *TextObject {
+	if strings.TrimSpace(caption) == "" {
+		return plainText(noCaption)
+	}
+	return plainText(truncateString(caption))
+}
+
+func newSectionBlock(text *TextObject, accessory BlockElement) *SectionBlock {
+	return &SectionBlock{Type: blockSection, Text: text, Accessory: accessory}
+}
+
+func newContextBlock(elements ...BlockElement) *ContextBlock {
+	return &ContextBlock{Type: blockContext, Elements: elements}
+}
+
+func newActionsBlock(blockID string, elements ...BlockElement) *ActionsBlock {
+	return &ActionsBlock{Type: blockActions, BlockID: blockID, Elements: elements}
+}
+
+func newDividerBlock() *DividerBlock {
+	return &DividerBlock{Type: blockDivider}
+}
+
+func newImageElement(imageURL, altText string) *ImageElement {
+	return &ImageElement{Type: elementImage, ImageURL: imageURL, AltText: altText}
+}
+
+func newButtonElement(text, actionID, value string) *ButtonElement {
+	return &ButtonElement{Type: elementButton, Text: plainText(text), ActionID: actionID, Value: value}
+}
+
+/*
+	renderers
+*/
+
+// badgeBlock shows the top contributor/verified badge, if the user has one
+func badgeBlock(topContributor, verified bool) Block {
+	switch {
+	case topContributor:
+		return newContextBlock(newImageElement(topContributorBadgeLink, "Top Contributor badge"), plainText("Top Contributor"))
+	case verified:
+		return newContextBlock(newImageElement(verifiedBadgeLink, "Verified badge"), plainText("Verified"))
+	}
+	return nil
+}
+
+func shareBlock(label, link, opUser string) Block {
+	text := fmt.Sprintf("%v: %v", label, escapeMrkdwn(link))
+	if opUser != "" {
+		text += fmt.Sprintf(" | posted by @%v", escapeMrkdwn(opUser))
+	}
+	return newContextBlock(markdownText(text))
+}
+
+func generateCaseBlocks(data *fig1.Case, opUser string) []Block {
+	blocks := []Block{}
+
+	// author
+	author := fmt.Sprintf("*%v*", mrkdwnLink(userLinkGen(data.Author.Username), data.Author.Username))
+	blocks = append(blocks, newSectionBlock(markdownText(author), nil))
+	if badge := badgeBlock(data.Author.TopContributor, data.Author.Verified); badge != nil {
+		blocks = append(blocks, badge)
+	}
+
+	// case info
+	thumb := newImageElement(caseLinkGen("image", data.ID), "Figure 1 case image")
+	blocks = append(blocks, newSectionBlock(captionText(data.Caption), thumb))
+	blocks = append(blocks, newContextBlock(plainText(withCachedNote(caseStats(data), data.CachedAt))))
+
+	// share links
+	blocks = append(blocks, shareBlock("Share case link", caseLinkGen("case", data.ID), opUser))
+
+	return blocks
+}
+
+func generateUserBlocks(data *fig1.User, opUser string) []Block {
+	blocks := []Block{}
+
+	// main section
+	title := fmt.Sprintf("*%v*\n%v, %v", mrkdwnLink(userLinkGen(data.Username), data.Username), escapeMrkdwn(data.Category), escapeMrkdwn(data.Specialty))
+	blocks = append(blocks, newSectionBlock(markdownText(title), nil))
+	if badge := badgeBlock(data.TopContributor, data.Verified); badge != nil {
+		blocks = append(blocks, badge)
+	}
+
+	// extra content section
+	var extra []string
+	if data.FullName != "" {
+		extra = append(extra, "*"+escapeMrkdwn(data.FullName)+"*")
+	}
+	if data.Bio != "" {
+		extra = append(extra, escapeMrkdwn(data.Bio))
+	}
+	extraSection := &SectionBlock{Type: blockSection}
+	if len(extra) > 0 {
+		extraSection.Text = markdownText(strings.Join(extra, "\n"))
+	}
+	if data.Link != "" {
+		extraSection.Fields = append(extraSection.Fields, markdownText("*Link*\n"+escapeMrkdwn(data.Link)))
+	}
+	if loc := userLocation(data); loc != "" {
+		extraSection.Fields = append(extraSection.Fields, markdownText("*Institution/Country*\n"+escapeMrkdwn(loc)))
+	}
+	if extraSection.Text != nil || len(extraSection.Fields) > 0 {
+		blocks = append(blocks, extraSection)
+	}
+
+	// stats
+	if stats := withCachedNote(userStats(data), data.CachedAt); stats != "" {
+		blocks = append(blocks, newContextBlock(plainText(stats)))
+	}
+
+	// share links
+	blocks = append(blocks, shareBlock("Share profile link", userLinkGen(data.Username), opUser))
+
+	return blocks
+}
+
+func generateCollectionBlocks(data *fig1.Collection, opUser string) []Block {
+	blocks := []Block{}
+
+	// collection info
+	info := "*" + escapeMrkdwn(data.Title) + "*"
+	if len(data.Embedded.Authors) > 0 {
+		author := data.Embedded.Authors[0]
+		info += "\nby " + mrkdwnLink(userLinkGen(author.Username), author.Username)
+	}
+	if data.Description != "" {
+		info += "\n" + escapeMrkdwn(truncateString(data.Description))
+	}
+	blocks = append(blocks, newSectionBlock(markdownText(info), nil))
+	blocks = append(blocks, newContextBlock(plainText(withCachedNote(collectionSize(data), data.CachedAt))))
+
+	// items
+	items := data.Embedded.Items
+	length := 3
+	if len(items) < length {
+		length = len(items)
+	}
+	for _, item := range items[0:length] {
+		blocks = append(blocks, newDividerBlock())
+		thumb := newImageElement(genCollectionItemImageLink(item.Links.Image.Href, item.ID), "Figure 1 case image")
+		blocks = append(blocks, newSectionBlock(captionText(item.Caption), thumb))
+		blocks = append(blocks, newContextBlock(plainText(itemStats(item.VoteCount, item.CommentCount, item.Followers))))
+	}
+
+	// share links
+	blocks = append(blocks, newDividerBlock())
+	blocks = append(blocks, shareBlock("Share collection link", collectionLinkGen(data.ID), opUser))
+
+	return blocks
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"app/fig1"
+)
+
+// renderJSON is a response as slack would receive it, without go's html escaping
+func renderJSON(t *testing.T, content *SlackResponse) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(content); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func parseCollection(t *testing.T, data string) *fig1.Collection {
+	var collection fig1.Collection
+	if err := json.Unmarshal([]byte(data), &collection); err != nil {
+		t.Fatal(err)
+	}
+	return &collection
+}
+
+func TestBlocksRenderer(t *testing.T) {
+	r := blocksRenderer{}
+	hostileUser := &fig1.User{
+		Username:  "a|b>",
+		Category:  "<!channel>",
+		Specialty: "R&D",
+		FullName:  "<@U123>",
+		Bio:       "<https://evil.example|click me>",
+	}
+
+	tests := []struct {
+		name     string
+		content  *SlackResponse
+		contains []string
+		excludes []string
+	}{
+		{
+			"case",
+			r.renderCase(&fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "Chest x-ray", ImageViews: "10 views"}, "bob"),
+			[]string{`"text":"Chest x-ray"`, "posted by @bob", "img/share/59076d6324d11b594b2dff1d"},
+			nil,
+		},
+		{
+			"case without caption",
+			r.renderCase(&fig1.Case{ID: "59076d6324d11b594b2dff1d"}, ""),
+			[]string{`"text":"` + noCaption + `"`},
+			[]string{`"text":""`, "posted by"},
+		},
+		{
+			"user",
+			r.renderUser(&fig1.User{Username: "ccovic", Category: "Physician", Specialty: "Cardiology", Bio: "Heart doc"}, ""),
+			[]string{"*<https://app.figure1.com/rd/publicprofile?username=ccovic|ccovic>*", "Physician, Cardiology", "Heart doc"},
+			nil,
+		},
+		{
+			"hostile user",
+			r.renderUser(hostileUser, "<!here>"),
+			[]string{"?username=a%7Cb%3E|a|b&gt;>", "&lt;!channel&gt;", "R&amp;D", "*&lt;@U123&gt;*", "&lt;https://evil.example|click me&gt;", "@&lt;!here&gt;"},
+			[]string{"<!channel>", "<@U123>", "<!here>", "<https://evil.example"},
+		},
+		{
+			"user with url characters",
+			r.renderUser(&fig1.User{Username: "a b&c=d#e"}, ""),
+			[]string{"?username=a+b%26c%3Dd%23e|"},
+			[]string{"username=a b"},
+		},
+		{
+			"collection",
+			r.renderCollection(parseCollection(t, `{"id":"5907549889c89eef5b1b3511","title":"Cardiology","description":"Hearts","size":1,
+				"_embedded":{"authors":[{"username":"ccovic"}],"items":[{"_id":"1","caption":"ECG"}]}}`), ""),
+			[]string{"*Cardiology*\\nby <https://app.figure1.com/rd/publicprofile?username=ccovic|ccovic>", `"text":"ECG"`},
+			nil,
+		},
+		{
+			"collection without authors or captions",
+			r.renderCollection(parseCollection(t, `{"id":"5907549889c89eef5b1b3511","title":"<!channel> & co","_embedded":{"items":[{"_id":"1"}]}}`), ""),
+			[]string{"*&lt;!channel&gt; &amp; co*", `"text":"` + noCaption + `"`},
+			[]string{"by <", "<!channel>", `"text":""`},
+		},
+	}
+	for _, test := range tests {
+		rendered := renderJSON(t, test.content)
+		for _, want := range test.contains {
+			if !strings.Contains(rendered, want) {
+				t.Errorf("Expected %v to contain %q, got %v", test.name, want, rendered)
+			}
+		}
+		for _, unwanted := range test.excludes {
+			if strings.Contains(rendered, unwanted) {
+				t.Errorf("Expected %v not to contain %q, got %v", test.name, unwanted, rendered)
+			}
+		}
+
+		// slack rejects the whole message if a section doesn't have any text
+		for _, block := range test.content.Blocks {
+			if section, ok := block.(*SectionBlock); ok && (section.Text == nil || section.Text.Text == "") && len(section.Fields) == 0 {
+				t.Errorf("Expected every %v section to have text, got %+v", test.name, section)
+			}
+		}
+	}
+}
+
+func TestLegacyCollectionWithoutAuthors(t *testing.T) {
+	content := legacyRenderer{}.renderCollection(parseCollection(t, `{"id":"5907549889c89eef5b1b3511","title":"Cardiology"}`), "")
+	if len(content.Attachments) == 0 || content.Attachments[0].AuthorName != "" {
+		t.Errorf("Expected a collection without an author, got %+v", content.Attachments)
+	}
+}
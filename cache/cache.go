@@ -0,0 +1,20 @@
+// Package cache stores encoded figure 1 responses along with when they were fetched
+package cache
+
+import (
+	"time"
+)
+
+// Entry is a cached value and when it was fetched from upstream
+type Entry struct {
+	Value     []byte    `json:"value"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// Backend stores entries by key. Backends decide for themselves when to evict
+// entries, callers decide whether an entry is still fresh.
+type Backend interface {
+	Get(key string) (Entry, bool)
+	Set(key string, entry Entry) error
+	Delete(key string) error
+}
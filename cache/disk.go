@@ -0,0 +1,247 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DiskFile is the name of the file Disk keeps in its data dir
+const DiskFile = "cache.db"
+
+// Disk is a backend that keeps entries in a single append-only file, so the
+// cache survives restarts. Overwrites and deletes leave the old records behind
+// until Compact rewrites the file.
+type Disk struct {
+	path string
+
+	mu      sync.RWMutex
+	file    *os.File
+	size    int64
+	index   map[string]diskRecord
+	garbage int
+}
+
+// diskRecord is where the latest line for a key is in the file
+type diskRecord struct {
+	offset int64
+	length int
+}
+
+// diskLine is one json line in the file, a nil Entry deletes the key
+type diskLine struct {
+	Key   string `json:"key"`
+	Entry *Entry `json:"entry,omitempty"`
+}
+
+// OpenDisk opens (or creates) the cache file in dir
+func OpenDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	d := &Disk{path: filepath.Join(dir, DiskFile)}
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Disk) open() error {
+	file, err := os.OpenFile(d.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	d.file = file
+	d.size = 0
+	d.index = map[string]diskRecord{}
+	d.garbage = 0
+
+	if err := d.load(); err != nil {
+		file.Close()
+		return err
+	}
+	return nil
+}
+
+// load rebuilds the index. A partly written last line (eg: after a crash) is cut off.
+func (d *Disk) load() error {
+	reader := bufio.NewReader(d.file)
+	var offset int64
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var line diskLine
+		if json.Unmarshal(data, &line) != nil || line.Key == "" {
+			break
+		}
+
+		if _, ok := d.index[line.Key]; ok {
+			d.garbage++
+		}
+		if line.Entry == nil {
+			delete(d.index, line.Key)
+			d.garbage++
+		} else {
+			d.index[line.Key] = diskRecord{offset, len(data)}
+		}
+		offset += int64(len(data))
+	}
+
+	d.size = offset
+	return d.file.Truncate(offset)
+}
+
+// Get reads the entry for key from the file
+func (d *Disk) Get(key string) (Entry, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rec, ok := d.index[key]
+	if !ok {
+		return Entry{}, false
+	}
+
+	data := make([]byte, rec.length)
+	if _, err := d.file.ReadAt(data, rec.offset); err != nil {
+		return Entry{}, false
+	}
+	var line diskLine
+	if err := json.Unmarshal(data, &line); err != nil || line.Entry == nil {
+		return Entry{}, false
+	}
+	return *line.Entry, true
+}
+
+// Set appends an entry to the file
+func (d *Disk) Set(key string, entry Entry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rec, err := d.write(diskLine{Key: key, Entry: &entry})
+	if err != nil {
+		return err
+	}
+	if _, ok := d.index[key]; ok {
+		d.garbage++
+	}
+	d.index[key] = rec
+	return nil
+}
+
+// Delete appends a tombstone for key, if it exists
+func (d *Disk) Delete(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.index[key]; !ok {
+		return nil
+	}
+	if _, err := d.write(diskLine{Key: key}); err != nil {
+		return err
+	}
+	delete(d.index, key)
+	d.garbage += 2
+	return nil
+}
+
+func (d *Disk) write(line diskLine) (diskRecord, error) {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return diskRecord{}, err
+	}
+	data = append(data, '\n')
+
+	if _, err := d.file.WriteAt(data, d.size); err != nil {
+		return diskRecord{}, err
+	}
+	rec := diskRecord{d.size, len(data)}
+	d.size += int64(len(data))
+	return rec, nil
+}
+
+// Compact rewrites the file with only the live entries, dropping any that were
+// fetched more than maxAge ago (0 keeps everything). Returns the number of entries evicted.
+func (d *Disk) Compact(maxAge time.Duration) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(d.path), DiskFile+".compact")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	evicted := 0
+	writer := bufio.NewWriter(tmp)
+	for _, rec := range d.index {
+		data := make([]byte, rec.length)
+		if _, err := d.file.ReadAt(data, rec.offset); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+
+		if maxAge > 0 {
+			var line diskLine
+			if err := json.Unmarshal(data, &line); err != nil || line.Entry == nil || time.Since(line.Entry.FetchedAt) > maxAge {
+				evicted++
+				continue
+			}
+		}
+		if _, err := writer.Write(data); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	d.file.Close()
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		// the old file is still there, carry on using it
+		return 0, d.open()
+	}
+	return evicted, d.open()
+}
+
+// Garbage is the number of dead records that Compact would remove
+func (d *Disk) Garbage() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.garbage
+}
+
+// Len is the number of live entries
+func (d *Disk) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.index)
+}
+
+// Close closes the file
+func (d *Disk) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.file.Close()
+}
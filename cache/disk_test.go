@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDisk(t *testing.T) (*Disk, string) {
+	dir, err := ioutil.TempDir("", "fig1-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenDisk(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return d, dir
+}
+
+func TestDiskPersists(t *testing.T) {
+	d, dir := openTestDisk(t)
+	defer os.RemoveAll(dir)
+	fetchedAt := time.Now().Add(-time.Minute).Round(time.Second)
+
+	d.Set("a", Entry{Value: []byte(`{"caption": "old"}`), FetchedAt: fetchedAt})
+	d.Set("a", Entry{Value: []byte(`{"caption": "new"}`), FetchedAt: fetchedAt})
+	d.Set("b", Entry{Value: []byte(`{}`), FetchedAt: fetchedAt})
+	d.Delete("b")
+	d.Close()
+
+	d, err := OpenDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	entry, ok := d.Get("a")
+	if !ok || string(entry.Value) != `{"caption": "new"}` || !entry.FetchedAt.Equal(fetchedAt) {
+		t.Errorf("Expected latest \"a\" after reopening, got %s at %v (ok: %v)", entry.Value, entry.FetchedAt, ok)
+	}
+	if _, ok := d.Get("b"); ok {
+		t.Errorf("Expected \"b\" to stay deleted after reopening")
+	}
+	if d.Len() != 1 {
+		t.Errorf("Expected 1 entry, got %v", d.Len())
+	}
+}
+
+func TestDiskCompact(t *testing.T) {
+	d, dir := openTestDisk(t)
+	defer os.RemoveAll(dir)
+	defer d.Close()
+
+	d.Set("fresh", Entry{Value: []byte("1"), FetchedAt: time.Now()})
+	d.Set("fresh", Entry{Value: []byte("2"), FetchedAt: time.Now()})
+	d.Set("old", Entry{Value: []byte("3"), FetchedAt: time.Now().Add(-48 * time.Hour)})
+	d.Set("deleted", Entry{Value: []byte("4"), FetchedAt: time.Now()})
+	d.Delete("deleted")
+
+	evicted, err := d.Compact(24 * time.Hour)
+	if err != nil || evicted != 1 {
+		t.Errorf("Expected 1 entry to be evicted, got %v (err: %v)", evicted, err)
+	}
+	if d.Garbage() != 0 || d.Len() != 1 {
+		t.Errorf("Expected 1 entry and no garbage after compacting, got %v and %v", d.Len(), d.Garbage())
+	}
+	if entry, ok := d.Get("fresh"); !ok || string(entry.Value) != "2" {
+		t.Errorf("Expected \"fresh\" to survive compaction, got %s (ok: %v)", entry.Value, ok)
+	}
+
+	// still writable after the file is swapped
+	d.Set("new", Entry{Value: []byte("5"), FetchedAt: time.Now()})
+	if _, ok := d.Get("new"); !ok {
+		t.Errorf("Expected to set entries after compacting")
+	}
+}
+
+func TestDiskTruncatedWrite(t *testing.T) {
+	d, dir := openTestDisk(t)
+	defer os.RemoveAll(dir)
+
+	d.Set("a", Entry{Value: []byte("1"), FetchedAt: time.Now()})
+	d.Close()
+
+	// a crash in the middle of writing "b"
+	file, err := os.OpenFile(filepath.Join(dir, DiskFile), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"key":"b","entry":{"Val`)
+	file.Close()
+
+	d, err = OpenDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if _, ok := d.Get("a"); !ok {
+		t.Errorf("Expected \"a\" to survive a partial write")
+	}
+	d.Set("c", Entry{Value: []byte("3"), FetchedAt: time.Now()})
+	if entry, ok := d.Get("c"); !ok || string(entry.Value) != "3" {
+		t.Errorf("Expected to write over the partial line, got %s (ok: %v)", entry.Value, ok)
+	}
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryLRU(t *testing.T) {
+	m := NewMemory(2)
+	now := time.Now()
+
+	m.Set("a", Entry{Value: []byte("1"), FetchedAt: now})
+	m.Set("b", Entry{Value: []byte("2"), FetchedAt: now})
+
+	// using "a" makes "b" the least recently used
+	if entry, ok := m.Get("a"); !ok || string(entry.Value) != "1" {
+		t.Errorf("Expected to get \"a\", got %v (ok: %v)", entry, ok)
+	}
+	m.Set("c", Entry{Value: []byte("3"), FetchedAt: now})
+
+	if _, ok := m.Get("b"); ok {
+		t.Errorf("Expected \"b\" to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := m.Get(key); !ok {
+			t.Errorf("Expected \"%v\" to still be cached", key)
+		}
+	}
+	if m.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %v", m.Len())
+	}
+
+	m.Delete("a")
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("Expected \"a\" to be deleted")
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"app/cache"
+	"app/fig1"
+	"app/logging"
+)
+
+const (
+	defaultCaseTTL       = 5 * time.Minute
+	defaultUserTTL       = time.Hour
+	defaultCollectionTTL = 15 * time.Minute
+
+	// how long to wait for figure 1 before giving up and serving a stale copy,
+	// the request carries on in the background and updates the cache
+	defaultStaleTimeout = 5 * time.Second
+	// stale entries older than this are never served
+	defaultMaxStale = 24 * time.Hour
+	// upstream requests are shared between callers, so they get their own timeout
+	upstreamTimeout = 30 * time.Second
+
+	// the disk cache is rewritten this often, dropping entries too old to be served
+	cacheCompactInterval = time.Hour
+	defaultDataDir       = "data"
+)
+
+type skipCacheKey struct{}
+
+// skipCache makes the cached client fetch from figure 1 even if there's a fresh
+// copy, eg: when someone clicks "Refresh stats"
+func skipCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipCacheKey{}, true)
+}
+
+// cachedClient wraps a figure 1 client with a cache. Concurrent lookups of the
+// same key share one upstream request, and stale copies are served when figure 1
+// fails or is too slow.
+type cachedClient struct {
+	inner        fig1.Client
+	backend      cache.Backend
+	prefix       string // for keys, eg: a workspace with its own figure 1 account
+	ttls         map[string]time.Duration
+	staleTimeout time.Duration
+	maxStale     time.Duration
+	now          func() time.Time
+
+	mu       sync.Mutex
+	inflight map[string]*upstreamCall
+}
+
+// upstreamCall is a figure 1 request that one or more callers are waiting on
+type upstreamCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+func newCachedClient(inner fig1.Client, backend cache.Backend, ttls map[string]time.Duration) *cachedClient {
+	c := &cachedClient{
+		inner:   inner,
+		backend: backend,
+		ttls: map[string]time.Duration{
+			contentCase:       defaultCaseTTL,
+			contentUser:       defaultUserTTL,
+			contentCollection: defaultCollectionTTL,
+		},
+		staleTimeout: defaultStaleTimeout,
+		maxStale:     defaultMaxStale,
+		now:          time.Now,
+		inflight:     map[string]*upstreamCall{},
+	}
+	for kind, ttl := range ttls {
+		c.ttls[kind] = ttl
+	}
+	return c
+}
+
+func cacheKey(kind, id string) string {
+	return kind + ":" + id
+}
+
+func (c *cachedClient) Case(ctx context.Context, id string) (*fig1.Case, error) {
+	var body fig1.Case
+	fetch := func(ctx context.Context) (interface{}, error) {
+		return c.inner.Case(ctx, id)
+	}
+	cachedAt, err := c.get(ctx, contentCase, id, fetch, &body)
+	if err != nil {
+		return nil, err
+	}
+	body.CachedAt = cachedAt
+	return &body, nil
+}
+
+func (c *cachedClient) User(ctx context.Context, username string) (*fig1.User, error) {
+	var body fig1.User
+	fetch := func(ctx context.Context) (interface{}, error) {
+		return c.inner.User(ctx, username)
+	}
+	cachedAt, err := c.get(ctx, contentUser, username, fetch, &body)
+	if err != nil {
+		return nil, err
+	}
+	body.CachedAt = cachedAt
+	return &body, nil
+}
+
+func (c *cachedClient) Collection(ctx context.Context, id string) (*fig1.Collection, error) {
+	var body fig1.Collection
+	fetch := func(ctx context.Context) (interface{}, error) {
+		return c.inner.Collection(ctx, id)
+	}
+	cachedAt, err := c.get(ctx, contentCollection, id, fetch, &body)
+	if err != nil {
+		return nil, err
+	}
+	body.CachedAt = cachedAt
+	return &body, nil
+}
+
+func (c *cachedClient) Login(ctx context.Context) error {
+	return c.inner.Login(ctx)
+}
+
+// get decodes the cached or freshly fetched value into out. The returned time
+// is when a stale copy was fetched, and is zero for fresh content.
+func (c *cachedClient) get(ctx context.Context, kind, id string, fetch func(context.Context) (interface{}, error), out interface{}) (time.Time, error) {
+	key := c.prefix + cacheKey(kind, id)
+	entry, cached := c.backend.Get(key)
+	age := c.now().Sub(entry.FetchedAt)
+	if cached && age > c.maxStale {
+		cached = false
+	}
+
+	skip, _ := ctx.Value(skipCacheKey{}).(bool)
+	if cached && !skip && age < c.ttls[kind] {
+		cacheLookupsTotal.Inc(kind, "hit")
+		return time.Time{}, json.Unmarshal(entry.Value, out)
+	}
+
+	call := c.fetch(ctx, key, fetch)
+
+	// only wait a little while if there's something to fall back to
+	var timeout <-chan time.Time
+	if cached {
+		timer := time.NewTimer(c.staleTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-call.done:
+		if call.err == nil {
+			cacheLookupsTotal.Inc(kind, "miss")
+			return time.Time{}, json.Unmarshal(call.value, out)
+		}
+		// the content is gone, an old copy shouldn't be shown
+		if fig1.IsNotFound(call.err) || !cached {
+			cacheLookupsTotal.Inc(kind, "miss")
+			return time.Time{}, call.err
+		}
+		loggerFrom(ctx).Warn("Serving stale content", "key", key, "fetched", entry.FetchedAt, "err", call.err)
+	case <-timeout:
+		loggerFrom(ctx).Warn("Serving stale content, figure 1 timed out", "key", key, "fetched", entry.FetchedAt)
+	case <-ctx.Done():
+		if !cached {
+			cacheLookupsTotal.Inc(kind, "miss")
+			return time.Time{}, ctx.Err()
+		}
+	}
+
+	cacheLookupsTotal.Inc(kind, "stale")
+	return entry.FetchedAt, json.Unmarshal(entry.Value, out)
+}
+
+// fetch starts an upstream request for key, or joins the one that's already running.
+// The request logs with whichever caller started it.
+func (c *cachedClient) fetch(caller context.Context, key string, fetch func(context.Context) (interface{}, error)) *upstreamCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.inflight[key]; ok {
+		return call
+	}
+
+	call := &upstreamCall{done: make(chan struct{})}
+	c.inflight[key] = call
+
+	go func() {
+		ctx, cancel := context.WithTimeout(logging.NewContext(context.Background(), loggerFrom(caller)), upstreamTimeout)
+		defer cancel()
+
+		value, err := fetch(ctx)
+		if err == nil {
+			call.value, err = json.Marshal(value)
+		}
+		call.err = err
+
+		switch {
+		case err == nil:
+			if err := c.backend.Set(key, cache.Entry{Value: call.value, FetchedAt: c.now()}); err != nil {
+				loggerFrom(ctx).Error("Failed to cache content", "key", key, "err", err)
+			}
+		case fig1.IsNotFound(err):
+			c.backend.Delete(key)
+		}
+
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	return call
+}
+
+// compactCache periodically removes overwritten, deleted and expired entries from the disk cache
+func compactCache(disk *cache.Disk, interval time.Duration) {
+	for range time.Tick(interval) {
+		evicted, err := disk.Compact(defaultMaxStale)
+		if err != nil {
+			logger.Error("Failed to compact cache", "err", err)
+			continue
+		}
+		if evicted > 0 {
+			logger.Info("Evicted expired cache entries", "count", evicted)
+		}
+	}
+}
+
+// parseCacheTTLs reads ttls from the config, eg: {"case": "5m"}
+func parseCacheTTLs(conf map[string]string) (map[string]time.Duration, error) {
+	ttls := map[string]time.Duration{}
+	for kind, value := range conf {
+		switch kind {
+		case contentCase, contentUser, contentCollection:
+		default:
+			return nil, fmt.Errorf("unknown content type %q in cache_ttls", kind)
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache ttl for %v: %v", kind, err)
+		}
+		ttls[kind] = ttl
+	}
+	return ttls, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"app/cache"
+	"app/fig1"
+)
+
+// slowClient holds every case lookup until release is closed
+type slowClient struct {
+	*fig1.Fake
+	release chan struct{}
+}
+
+func (c *slowClient) Case(ctx context.Context, id string) (*fig1.Case, error) {
+	<-c.release
+	return c.Fake.Case(ctx, id)
+}
+
+func newTestCache(inner fig1.Client) (*cachedClient, *time.Time) {
+	now := time.Now()
+	c := newCachedClient(inner, cache.NewMemory(10), nil)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestCacheTTL(t *testing.T) {
+	fake := fig1.NewFake()
+	fake.Cases["abc"] = &fig1.Case{ID: "abc", Caption: "first"}
+	c, now := newTestCache(fake)
+	ctx := context.Background()
+
+	c.Case(ctx, "abc")
+	fake.Cases["abc"] = &fig1.Case{ID: "abc", Caption: "second"}
+
+	if f1Case, _ := c.Case(ctx, "abc"); f1Case.Caption != "first" || fake.Calls["case"] != 1 {
+		t.Errorf("Expected cached case within ttl, got %v after %v calls", f1Case.Caption, fake.Calls["case"])
+	}
+	if f1Case, _ := c.Case(skipCache(ctx), "abc"); f1Case.Caption != "second" {
+		t.Errorf("Expected skipCache to fetch again, got %v", f1Case.Caption)
+	}
+
+	fake.Cases["abc"] = &fig1.Case{ID: "abc", Caption: "third"}
+	*now = now.Add(defaultCaseTTL + time.Second)
+	f1Case, err := c.Case(ctx, "abc")
+	if err != nil || f1Case.Caption != "third" || !f1Case.CachedAt.IsZero() {
+		t.Errorf("Expected expired case to be fetched again, got %+v (err: %v)", f1Case, err)
+	}
+}
+
+func TestCacheServesStale(t *testing.T) {
+	fake := fig1.NewFake()
+	fake.Cases["abc"] = &fig1.Case{ID: "abc", Caption: "cached"}
+	c, now := newTestCache(fake)
+	ctx := context.Background()
+
+	fetchedAt := *now
+	c.Case(ctx, "abc")
+	*now = now.Add(time.Hour)
+
+	// figure 1 is down
+	fake.Err = &fig1.Error{Kind: fig1.Upstream, Status: 502}
+	f1Case, err := c.Case(ctx, "abc")
+	if err != nil || f1Case.Caption != "cached" {
+		t.Fatalf("Expected stale case when figure 1 fails, got %+v (err: %v)", f1Case, err)
+	}
+	if !f1Case.CachedAt.Equal(fetchedAt) {
+		t.Errorf("Expected stale case to say when it was fetched (%v), got %v", fetchedAt, f1Case.CachedAt)
+	}
+
+	// the case was deleted
+	fake.Err = &fig1.Error{Kind: fig1.NotFound, Status: 404}
+	if _, err := c.Case(ctx, "abc"); !fig1.IsNotFound(err) {
+		t.Errorf("Expected NotFound not to be hidden by the cache, got %v", err)
+	}
+
+	// nothing to fall back on
+	fake.Err = &fig1.Error{Kind: fig1.Upstream, Status: 502}
+	if _, err := c.Case(ctx, "missing"); !fig1.IsUpstream(err) {
+		t.Errorf("Expected upstream error without a cached copy, got %v", err)
+	}
+}
+
+func TestCacheServesStaleOnTimeout(t *testing.T) {
+	slow := &slowClient{Fake: fig1.NewFake(), release: make(chan struct{})}
+	slow.Cases["abc"] = &fig1.Case{ID: "abc", Caption: "cached"}
+	c, now := newTestCache(slow)
+	c.staleTimeout = 10 * time.Millisecond
+	c.backend.Set(cacheKey(contentCase, "abc"), cache.Entry{Value: []byte(`{"_id": "abc", "caption": "cached"}`), FetchedAt: *now})
+	*now = now.Add(time.Hour)
+
+	f1Case, err := c.Case(context.Background(), "abc")
+	close(slow.release)
+	if err != nil || f1Case.CachedAt.IsZero() {
+		t.Errorf("Expected stale case when figure 1 is slow, got %+v (err: %v)", f1Case, err)
+	}
+}
+
+func TestCacheSharesUpstreamCalls(t *testing.T) {
+	slow := &slowClient{Fake: fig1.NewFake(), release: make(chan struct{})}
+	slow.Cases["abc"] = &fig1.Case{ID: "abc", Caption: "shared"}
+	c, _ := newTestCache(slow)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if f1Case, err := c.Case(context.Background(), "abc"); err != nil || f1Case.Caption != "shared" {
+				t.Errorf("Expected shared case, got %+v (err: %v)", f1Case, err)
+			}
+		}()
+	}
+
+	// wait until everyone is waiting on the same call
+	for {
+		c.mu.Lock()
+		call := c.inflight[cacheKey(contentCase, "abc")]
+		c.mu.Unlock()
+		if call != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(slow.release)
+	wg.Wait()
+
+	if calls := slow.Calls["case"]; calls != 1 {
+		t.Errorf("Expected concurrent lookups to share 1 upstream call, got %v", calls)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"app/settings"
+)
+
+const fig1Command = "/fig1"
+
+const (
+	flagPreview = "--preview"
+	flagPost    = "--post"
+)
+
+// subcommand is a `/fig1 <name>` command, also used to build the help screen.
+// Commands that talk to figure 1 set `handler` and respond via the `response_url`,
+// commands that can answer straight away set `reply` instead.
+type subcommand struct {
+	name        string
+	usage       string
+	description string
+
+	handler func(context.Context, *slashCommandRequestBody)
+	reply   func(*slashCommandRequestBody) *SlackResponse
+}
+
+// usageText is how to use the subcommand, as command (eg: `/fig1` or `/case`)
+func (cmd *subcommand) usageText(command string) string {
+	if command == fig1Command {
+		command += " " + cmd.name
+	}
+	return strings.TrimSpace(command + " " + cmd.usage)
+}
+
+// replyUsage is the reply to a subcommand that's missing its arguments
+func (cmd *subcommand) replyUsage(command string) *SlackResponse {
+	return &SlackResponse{
+		ResponseType: "ephemeral",
+		Text:         fmt.Sprintf("Usage: `%v` %v", cmd.usageText(command), cmd.description),
+	}
+}
+
+func (app *SlackApp) subcommands() []*subcommand {
+	return []*subcommand{
+		{
+			name:        contentCase,
+			usage:       "[case url or case id]",
+			description: "Display Figure 1 case info",
+			handler:     app.handleCase,
+		},
+		{
+			name:        contentUser,
+			usage:       "[username or profile url]",
+			description: "Get Figure 1 user",
+			handler:     app.handleUser,
+		},
+		{
+			name:        contentCollection,
+			usage:       "[collection id or collection url]",
+			description: "Display a collection preview",
+			handler:     app.handleCollection,
+		},
+		{
+			name:        "preview",
+			usage:       "[on|off]",
+			description: "Show previews only to you before they are posted in this channel",
+			reply:       app.replyPreview,
+		},
+		{
+			name:        "login",
+			description: "Look up content with your own Figure 1 account",
+			reply:       app.replyLogin,
+		},
+		{
+			name:        "logout",
+			description: "Go back to the app's shared Figure 1 account",
+			reply:       app.replyLogout,
+		},
+		{
+			name:        "whoami",
+			description: "Show which Figure 1 account your lookups use",
+			reply:       app.replyWhoami,
+		},
+		{
+			name:        "help",
+			description: "Show this message",
+			reply:       app.replyHelp,
+		},
+	}
+}
+
+func (app *SlackApp) findSubcommand(name string) *subcommand {
+	for _, cmd := range app.subcommands() {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// parseSubcommand splits `/fig1` text into the subcommand and its arguments.
+// A figure 1 link on its own is treated as the matching content subcommand.
+func (app *SlackApp) parseSubcommand(text string) (*subcommand, string) {
+	text = strings.TrimSpace(text)
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return app.findSubcommand("help"), ""
+	}
+
+	if cmd := app.findSubcommand(strings.ToLower(fields[0])); cmd != nil {
+		return cmd, strings.TrimSpace(strings.TrimPrefix(text, fields[0]))
+	}
+
+	if kind, _ := parseFig1Link(fields[0]); kind != "" {
+		return app.findSubcommand(kind), text
+	}
+
+	return &subcommand{
+		name: "unknown",
+		reply: func(body *slashCommandRequestBody) *SlackResponse {
+			resp := app.replyHelp(body)
+			resp.Text = fmt.Sprintf("Unknown command `%v`.\n\n", fields[0]) + resp.Text
+			return resp
+		},
+	}, text
+}
+
+func (app *SlackApp) replyHelp(body *slashCommandRequestBody) *SlackResponse {
+	lines := []string{"*Figure 1 commands*"}
+	for _, cmd := range app.subcommands() {
+		lines = append(lines, fmt.Sprintf("`%v` %v", cmd.usageText(fig1Command), cmd.description))
+	}
+	lines = append(lines, fmt.Sprintf("You can also paste a Figure 1 link straight after `%v`.", fig1Command))
+	lines = append(lines, fmt.Sprintf("Add `%v` or `%v` to override this channel's preview setting for one command.", flagPreview, flagPost))
+
+	return &SlackResponse{
+		ResponseType: "ephemeral",
+		Text:         strings.Join(lines, "\n"),
+	}
+}
+
+func (app *SlackApp) replyPreview(body *slashCommandRequestBody) *SlackResponse {
+	var text string
+	switch strings.ToLower(body.Text) {
+	case "on", "off":
+		preview := strings.ToLower(body.Text) == "on"
+		if err := app.setPreview(body.ChannelID, preview); err != nil {
+			logger.Error("Failed to save preview setting", "channel", body.ChannelID, "err", err)
+			text = "Failed to change this channel's preview setting, please try again later."
+		} else if preview {
+			text = "Previews in this channel will now be shown only to you first."
+		} else {
+			text = "Previews in this channel will now be posted straight away."
+		}
+	case "":
+		if app.previewEnabled(body.ChannelID) {
+			text = "Preview mode is on in this channel."
+		} else {
+			text = "Preview mode is off in this channel."
+		}
+	default:
+		text = fmt.Sprintf("Usage: `%v preview [on|off]`", fig1Command)
+	}
+
+	return &SlackResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+	}
+}
+
+// previewEnabled is whether a channel shows previews first, a setting changed
+// with `/fig1 preview` wins over `channels` in the config
+func (app *SlackApp) previewEnabled(channelID string) bool {
+	if app.channels != nil {
+		if channel, ok := app.channels.Get(channelID); ok {
+			return channel.Preview
+		}
+	}
+
+	app.channelsMu.RLock()
+	defer app.channelsMu.RUnlock()
+
+	settings, ok := app.Channels[channelID]
+	return ok && settings.Preview
+}
+
+// setPreview saves a channel's preview setting, or only keeps it in memory without a `data_dir`
+func (app *SlackApp) setPreview(channelID string, preview bool) error {
+	if app.channels != nil {
+		return app.channels.Save(channelID, settings.Channel{Preview: preview})
+	}
+
+	app.channelsMu.Lock()
+	defer app.channelsMu.Unlock()
+
+	if app.Channels == nil {
+		app.Channels = map[string]*channelSettings{}
+	}
+	if _, ok := app.Channels[channelID]; !ok {
+		app.Channels[channelID] = &channelSettings{}
+	}
+	app.Channels[channelID].Preview = preview
+	return nil
+}
+
+// parsePreviewFlags strips `--preview`/`--post` from the command text, which
+// override the channel's preview setting
+func parsePreviewFlags(text string, preview bool) (string, bool) {
+	var args []string
+	for _, field := range strings.Fields(text) {
+		switch field {
+		case flagPreview:
+			preview = true
+		case flagPost:
+			preview = false
+		default:
+			args = append(args, field)
+		}
+	}
+	return strings.Join(args, " "), preview
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"app/settings"
+)
+
+func TestParseSubcommand(t *testing.T) {
+	app := &SlackApp{}
+	cases := []struct {
+		text string
+		name string
+		args string
+	}{
+		{"", "help", ""},
+		{"help", "help", ""},
+		{"case 59076d6324d11b594b2dff1d", contentCase, "59076d6324d11b594b2dff1d"},
+		{"  USER   ccovic ", contentUser, "ccovic"},
+		{"collection https://app.figure1.com/collections/5907549889c89eef5b1b3511", contentCollection, "https://app.figure1.com/collections/5907549889c89eef5b1b3511"},
+		{"https://app.figure1.com/rd/image?imageid=59076d6324d11b594b2dff1d", contentCase, "https://app.figure1.com/rd/image?imageid=59076d6324d11b594b2dff1d"},
+		{"https://app.figure1.com/user/richardpenner", contentUser, "https://app.figure1.com/user/richardpenner"},
+		{"penguins", "unknown", "penguins"},
+	}
+	for _, c := range cases {
+		cmd, args := app.parseSubcommand(c.text)
+		if cmd.name != c.name || args != c.args {
+			t.Errorf("Expected \"%v\" to parse as %v (%v), got %v (%v)", c.text, c.name, c.args, cmd.name, args)
+		}
+	}
+}
+
+func TestParsePreviewFlags(t *testing.T) {
+	cases := []struct {
+		text     string
+		fallback bool
+		args     string
+		preview  bool
+	}{
+		{"case 59076d6324d11b594b2dff1d", false, "case 59076d6324d11b594b2dff1d", false},
+		{"case 59076d6324d11b594b2dff1d", true, "case 59076d6324d11b594b2dff1d", true},
+		{"--preview case 59076d6324d11b594b2dff1d", false, "case 59076d6324d11b594b2dff1d", true},
+		{"user ccovic --post", true, "user ccovic", false},
+	}
+	for _, c := range cases {
+		args, preview := parsePreviewFlags(c.text, c.fallback)
+		if args != c.args || preview != c.preview {
+			t.Errorf("Expected \"%v\" to parse as \"%v\" (preview: %v), got \"%v\" (preview: %v)", c.text, c.args, c.preview, args, preview)
+		}
+	}
+}
+
+func TestPreviewSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "channels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newApp := func() *SlackApp {
+		store, err := settings.OpenChannels(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &SlackApp{
+			Channels: map[string]*channelSettings{"CON": {Preview: true}, "COFF": {Preview: false}},
+			channels: store,
+		}
+	}
+	app := newApp()
+	if !app.previewEnabled("CON") || app.previewEnabled("COFF") || app.previewEnabled("CNEW") {
+		t.Errorf("Expected the config's channels to be the defaults")
+	}
+
+	for _, command := range []slashCommandRequestBody{
+		{ChannelID: "CON", Text: "off"},
+		{ChannelID: "CNEW", Text: "ON"},
+	} {
+		reply := app.replyPreview(&command)
+		if reply.ResponseType != "ephemeral" || !strings.Contains(reply.Text, "Previews in this channel") {
+			t.Errorf("Expected %v to be changed, got %v", command.ChannelID, reply.Text)
+		}
+	}
+
+	// changes are saved in the data dir, and win over the config
+	app = newApp()
+	tests := map[string]bool{"CON": false, "COFF": false, "CNEW": true, "COTHER": false}
+	for channelID, expected := range tests {
+		if preview := app.previewEnabled(channelID); preview != expected {
+			t.Errorf("Expected %v preview to be %v after restarting, got %v", channelID, expected, preview)
+		}
+	}
+	if reply := app.replyPreview(&slashCommandRequestBody{ChannelID: "CNEW"}); reply.Text != "Preview mode is on in this channel." {
+		t.Errorf("Expected CNEW's setting, got %v", reply.Text)
+	}
+}
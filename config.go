@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"app/cache"
+	"app/fig1"
+	"app/installs"
+	"app/logging"
+	"app/slackapi"
+)
+
+const (
+	defaultConfigFile    = "conf.json"
+	defaultListenAddress = ":3400"
+	defaultReadTimeout   = 10 * time.Second
+	defaultWriteTimeout  = 10 * time.Second
+	// docker waits 10 seconds before killing a container, use `--stop-grace-period` for longer
+	defaultShutdownTimeout = 10 * time.Second
+	defaultFig1Timeout     = 30 * time.Second
+
+	// env variables are the config key in upper case with this prefix, eg: FIG1_SIGNING_SECRET
+	envPrefix = "FIG1_"
+	// the config file can be set with `-config` or this env variable
+	envConfigFile = envPrefix + "CONFIG"
+
+	redacted = "[redacted]"
+)
+
+// duration is a time.Duration that's written as a string in config files, eg: "10s"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("durations should be strings like \"10s\", got %s", data)
+	}
+	return d.Set(value)
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+// configOption is a setting that can come from the config file, an env variable or a flag
+type configOption struct {
+	key    string
+	usage  string
+	secret bool
+	value  flag.Value
+}
+
+func (o configOption) env() string {
+	return envPrefix + strings.ToUpper(o.key)
+}
+
+func (o configOption) flag() string {
+	return strings.Replace(o.key, "_", "-", -1)
+}
+
+// display is the value for `check-config`, with secrets hidden
+func (o configOption) display() string {
+	value := o.value.String()
+	if o.secret && value != "" {
+		return redacted
+	}
+	return value
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(value string) error { *v.p = value; return nil }
+func (v stringValue) String() string         { return *v.p }
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expected a number, got %q", value)
+	}
+	*v.p = n
+	return nil
+}
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("expected true or false, got %q", value)
+	}
+	*v.p = b
+	return nil
+}
+func (v boolValue) String() string   { return strconv.FormatBool(*v.p) }
+func (v boolValue) IsBoolFlag() bool { return true }
+
+// deferredFlag holds a flag's value until the file and env have been applied
+type deferredFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *deferredFlag) Set(value string) error { f.value = value; return nil }
+func (f *deferredFlag) String() string         { return f.value }
+func (f *deferredFlag) IsBoolFlag() bool       { return f.isBool }
+
+// configOptions are the settings that can be changed without a config file.
+// Maps (`cache_ttls`, `workspaces` and `channels`) can only be set in the file.
+func (app *SlackApp) configOptions() []configOption {
+	return []configOption{
+		{key: "listen_address", usage: "address to listen on", value: stringValue{&app.ListenAddress}},
+		{key: "base_path", usage: "path prefix for every route, eg: /fig1-slack", value: stringValue{&app.BasePath}},
+		{key: "public_url", usage: "url the app is reachable at including base_path, for links sent to slack", value: stringValue{&app.PublicURL}},
+		{key: "read_timeout", usage: "timeout for reading requests", value: &app.ReadTimeout},
+		{key: "write_timeout", usage: "timeout for writing responses", value: &app.WriteTimeout},
+		{key: "shutdown_timeout", usage: "how long to wait for requests and queued jobs when stopping", value: &app.ShutdownTimeout},
+
+		{key: "figure1_app_url", usage: "figure 1 web app url", value: stringValue{&app.Fig1AppURL}},
+		{key: "figure1_api_url", usage: "figure 1 api url", value: stringValue{&app.Fig1APIURL}},
+		{key: "figure1_timeout", usage: "timeout for figure 1 requests", value: &app.Fig1Timeout},
+		{key: "email", usage: "figure 1 account email", value: stringValue{&app.Email}},
+		{key: "password", usage: "figure 1 account password", secret: true, value: stringValue{&app.Password}},
+
+		{key: "oauth_access_token", usage: "slack bot token", secret: true, value: stringValue{&app.OAuthAccessToken}},
+		{key: "signing_secret", usage: "slack signing secret", secret: true, value: stringValue{&app.SigningSecret}},
+		{key: "verification_token", usage: "legacy slack verification token", secret: true, value: stringValue{&app.VerificationToken}},
+		{key: "slack_api_url", usage: "slack web api url", value: stringValue{&app.SlackAPIURL}},
+		{key: "client_id", usage: "slack app client id, enables installing with oauth", value: stringValue{&app.ClientID}},
+		{key: "client_secret", usage: "slack app client secret", secret: true, value: stringValue{&app.ClientSecret}},
+		{key: "oauth_redirect_url", usage: "public url of /slack/oauth/callback, if there's more than one in the slack app", value: stringValue{&app.OAuthRedirectURL}},
+		{key: "encryption_key", usage: "32 byte base64 or hex key for bot tokens and figure 1 passwords and sessions saved in data_dir", secret: true, value: stringValue{&app.EncryptionKey}},
+		{key: "allow_legacy_token", usage: "accept unsigned requests with the verification token", value: boolValue{&app.AllowLegacyToken}},
+		{key: "socket_mode", usage: "receive slack requests over a websocket, so no public url is needed", value: boolValue{&app.SocketMode}},
+		{key: "app_token", usage: "slack app-level token with connections:write, for socket mode", secret: true, value: stringValue{&app.AppToken}},
+		{key: "message_format", usage: "legacy or blocks", value: stringValue{&app.MessageFormat}},
+
+		{key: "admin_token", usage: "bearer token for the admin endpoints", secret: true, value: stringValue{&app.AdminToken}},
+		{key: "cache_backend", usage: "memory or disk", value: stringValue{&app.CacheBackend}},
+		{key: "cache_size", usage: "max entries in the memory cache", value: intValue{&app.CacheSize}},
+		{key: "data_dir", usage: "directory for the disk cache, installs and channel settings", value: stringValue{&app.DataDir}},
+		{key: "workers", usage: "background workers", value: intValue{&app.Workers}},
+		{key: "queue_depth", usage: "max queued background jobs", value: intValue{&app.QueueDepth}},
+
+		{key: "log_format", usage: "text or json", value: stringValue{&app.LogFormat}},
+		{key: "log_level", usage: "debug, info, warn or error", value: stringValue{&app.LogLevel}},
+	}
+}
+
+func defaultConfig() *SlackApp {
+	return &SlackApp{
+		ListenAddress:   defaultListenAddress,
+		ReadTimeout:     duration(defaultReadTimeout),
+		WriteTimeout:    duration(defaultWriteTimeout),
+		ShutdownTimeout: duration(defaultShutdownTimeout),
+		Fig1AppURL:      fig1.DefaultAppURL,
+		Fig1APIURL:      fig1.DefaultAPIURL,
+		Fig1Timeout:     duration(defaultFig1Timeout),
+		SlackAPIURL:     slackapi.DefaultBaseURL,
+		MessageFormat:   formatLegacy,
+		CacheBackend:    "memory",
+		CacheSize:       cache.DefaultMemorySize,
+		DataDir:         defaultDataDir,
+		Workers:         defaultWorkers,
+		QueueDepth:      defaultQueueDepth,
+		LogFormat:       logging.Text,
+		LogLevel:        logging.Info.String(),
+	}
+}
+
+// loadConfig builds the config from defaults, then the config file, then env
+// variables, then flags. It returns the config file that was used, if any.
+func loadConfig(args []string, getenv func(string) string) (*SlackApp, string, error) {
+	app := defaultConfig()
+	options := app.configOptions()
+
+	// flags are parsed first to find the config file, but applied last
+	flags := flag.NewFlagSet("fig1-slack", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	configFile := flags.String("config", "", "config file (json or yaml)")
+	flagValues := map[string]*deferredFlag{}
+	for _, option := range options {
+		value := &deferredFlag{}
+		if b, ok := option.value.(boolValue); ok {
+			value.isBool = b.IsBoolFlag()
+		}
+		flagValues[option.flag()] = value
+		flags.Var(value, option.flag(), option.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if flags.NArg() > 0 {
+		return nil, "", fmt.Errorf("unexpected arguments %v", flags.Args())
+	}
+
+	// config file
+	path := *configFile
+	if path == "" {
+		path = getenv(envConfigFile)
+	}
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			path = defaultConfigFile
+		}
+	}
+	if path != "" {
+		if err := app.loadConfigFile(path); err != nil {
+			return nil, "", fmt.Errorf("error loading %v: %v", path, err)
+		}
+	}
+
+	// env
+	for _, option := range options {
+		if value := getenv(option.env()); value != "" {
+			if err := option.value.Set(value); err != nil {
+				return nil, "", fmt.Errorf("invalid %v: %v", option.env(), err)
+			}
+		}
+	}
+
+	// flags
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		value, ok := flagValues[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		for _, option := range options {
+			if option.flag() == f.Name {
+				if setErr := option.value.Set(value.value); setErr != nil {
+					err = fmt.Errorf("invalid -%v: %v", f.Name, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return app, path, nil
+}
+
+// loadConfigFile decodes a json or yaml (by extension) config file over the current values
+func (app *SlackApp) loadConfigFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err := parseYAML(data)
+		if err != nil {
+			return err
+		}
+		// yaml is converted to json so both use the same field names
+		if data, err = json.Marshal(values); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, app)
+}
+
+// validateConfig returns every problem with the config, rather than just the first one
+func (app *SlackApp) validateConfig() []string {
+	var problems []string
+	if app.Email == "" || app.Password == "" {
+		problems = append(problems, "email and password are required")
+	}
+	if app.SigningSecret == "" && !app.SocketMode && !(app.AllowLegacyToken && app.VerificationToken != "") {
+		problems = append(problems, "signing_secret is required")
+	}
+	if app.SocketMode && !strings.HasPrefix(app.AppToken, "xapp-") {
+		problems = append(problems, "app_token (xapp-...) is required for socket mode")
+	}
+	if app.AllowLegacyToken && app.VerificationToken == "" {
+		problems = append(problems, "verification_token is required when allow_legacy_token is true")
+	}
+	if app.ListenAddress == "" {
+		problems = append(problems, "listen_address is required")
+	}
+	if app.BasePath != "" && (!strings.HasPrefix(app.BasePath, "/") || strings.HasSuffix(app.BasePath, "/")) {
+		problems = append(problems, fmt.Sprintf("base_path should start with a / and not end with one, got %q", app.BasePath))
+	}
+	if app.PublicURL != "" && (!(strings.HasPrefix(app.PublicURL, "https://") || strings.HasPrefix(app.PublicURL, "http://")) || strings.HasSuffix(app.PublicURL, "/")) {
+		problems = append(problems, fmt.Sprintf("public_url should be an http(s) url without a trailing /, got %q", app.PublicURL))
+	}
+
+	for _, timeout := range []struct {
+		key   string
+		value duration
+	}{
+		{"read_timeout", app.ReadTimeout},
+		{"write_timeout", app.WriteTimeout},
+		{"shutdown_timeout", app.ShutdownTimeout},
+		{"figure1_timeout", app.Fig1Timeout},
+	} {
+		if timeout.value <= 0 {
+			problems = append(problems, timeout.key+" should be more than 0")
+		}
+	}
+	for _, count := range []struct {
+		key   string
+		value int
+	}{
+		{"cache_size", app.CacheSize},
+		{"workers", app.Workers},
+		{"queue_depth", app.QueueDepth},
+	} {
+		if count.value < 0 {
+			problems = append(problems, count.key+" can't be negative")
+		}
+	}
+
+	if app.MessageFormat != formatLegacy && app.MessageFormat != formatBlocks {
+		problems = append(problems, fmt.Sprintf("message_format should be %v or %v, got %q", formatLegacy, formatBlocks, app.MessageFormat))
+	}
+	for teamID, settings := range app.Workspaces {
+		if settings.MessageFormat != "" && settings.MessageFormat != formatLegacy && settings.MessageFormat != formatBlocks {
+			problems = append(problems, fmt.Sprintf("workspaces.%v.message_format should be %v or %v, got %q", teamID, formatLegacy, formatBlocks, settings.MessageFormat))
+		}
+	}
+
+	switch app.CacheBackend {
+	case "memory":
+	case "disk":
+		if app.DataDir == "" {
+			problems = append(problems, "data_dir is required for the disk cache")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("cache_backend should be memory or disk, got %q", app.CacheBackend))
+	}
+	if _, err := parseCacheTTLs(app.CacheTTLs); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if app.ClientID != "" {
+		if app.ClientSecret == "" {
+			problems = append(problems, "client_secret is required to install with oauth")
+		}
+		if app.EncryptionKey == "" {
+			problems = append(problems, "encryption_key is required to install with oauth")
+		}
+	}
+	if app.EncryptionKey != "" {
+		if _, err := installs.ParseKey(app.EncryptionKey); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if app.DataDir == "" {
+			problems = append(problems, "data_dir is required with an encryption_key")
+		}
+	}
+	if app.LogFormat != logging.Text && app.LogFormat != logging.JSON {
+		problems = append(problems, fmt.Sprintf("log_format should be %v or %v, got %q", logging.Text, logging.JSON, app.LogFormat))
+	}
+	if _, err := logging.ParseLevel(app.LogLevel); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// checkConfig is the `check-config` command, it prints the effective config
+// (with secrets redacted) and whether it's valid. Returns the exit code.
+func checkConfig(args []string, getenv func(string) string, out io.Writer) int {
+	app, path, err := loadConfig(args, getenv)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	if path == "" {
+		path = "(none)"
+	}
+	fmt.Fprintf(out, "config file: %v\n\n", path)
+	for _, option := range app.configOptions() {
+		fmt.Fprintf(out, "%-20v %v\n", option.key, option.display())
+	}
+	for _, setting := range []struct {
+		key   string
+		value interface{}
+	}{
+		{"cache_ttls", app.CacheTTLs},
+		{"workspaces", app.Workspaces},
+		{"channels", app.Channels},
+	} {
+		data, _ := json.Marshal(setting.value)
+		fmt.Fprintf(out, "%-20v %s\n", setting.key, data)
+	}
+
+	problems := app.validateConfig()
+	if len(problems) > 0 {
+		fmt.Fprintln(out, "\ninvalid config:")
+		for _, problem := range problems {
+			fmt.Fprintln(out, "- "+problem)
+		}
+		return 1
+	}
+	fmt.Fprintln(out, "\nconfig is valid")
+	return 0
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "fig1-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func testEnv(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	path, cleanup := writeTestConfig(t, "conf.json", `{
+		"email": "file@example.com",
+		"password": "file",
+		"workers": 2,
+		"read_timeout": "5s",
+		"cache_ttls": {"case": "1m"}
+	}`)
+	defer cleanup()
+
+	env := testEnv(map[string]string{
+		"FIG1_PASSWORD": "env",
+		"FIG1_WORKERS":  "6",
+	})
+	app, used, err := loadConfig([]string{"-config", path, "-workers", "8", "-allow-legacy-token"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if used != path {
+		t.Errorf("Expected config file %v, got %v", path, used)
+	}
+	tests := []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"default", app.ListenAddress, defaultListenAddress},
+		{"default", app.QueueDepth, defaultQueueDepth},
+		{"file", app.Email, "file@example.com"},
+		{"file", time.Duration(app.ReadTimeout), 5 * time.Second},
+		{"file", app.CacheTTLs["case"], "1m"},
+		{"env over file", app.Password, "env"},
+		{"flag over env", app.Workers, 8},
+		{"bool flag", app.AllowLegacyToken, true},
+	}
+	for _, test := range tests {
+		if test.got != test.expected {
+			t.Errorf("Expected %v value %v, got %v", test.name, test.expected, test.got)
+		}
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path, cleanup := writeTestConfig(t, "conf.yaml", `
+# figure 1
+email: yaml@example.com
+password: "p#ss: word" # quoted
+write_timeout: 20s
+cache_ttls:
+  case: 2m
+  user: 3h
+workspaces:
+  T0123ABCD:
+    message_format: blocks
+channels:
+  C1:
+    preview: true
+`)
+	defer cleanup()
+
+	app, _, err := loadConfig([]string{"-config", path}, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Email != "yaml@example.com" || app.Password != "p#ss: word" || time.Duration(app.WriteTimeout) != 20*time.Second {
+		t.Errorf("Expected yaml scalars to be loaded, got %v %v %v", app.Email, app.Password, app.WriteTimeout)
+	}
+	if app.CacheTTLs["user"] != "3h" || app.Workspaces["T0123ABCD"].MessageFormat != formatBlocks || !app.Channels["C1"].Preview {
+		t.Errorf("Expected nested yaml maps to be loaded, got %v %v %v", app.CacheTTLs, app.Workspaces, app.Channels)
+	}
+
+	for _, invalid := range []string{
+		"workers:\n  - 1\n",
+		"cache_ttls:\n  case: 1m\n   user: 1h\n",
+		"email\n",
+		"email: a\nemail: b\n",
+	} {
+		if _, err := parseYAML([]byte(invalid)); err == nil {
+			t.Errorf("Expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	app := defaultConfig()
+	app.Email = "a@example.com"
+	app.Password = "password"
+	app.SigningSecret = "secret"
+	if problems := app.validateConfig(); len(problems) != 0 {
+		t.Errorf("Expected valid config, got %v", problems)
+	}
+
+	app.BasePath = "fig1-slack/"
+	app.PublicURL = "example.com/fig1-slack"
+	app.CacheBackend = "redis"
+	app.CacheTTLs = map[string]string{"case": "soon"}
+	app.Workers = -1
+	if problems := app.validateConfig(); len(problems) != 5 {
+		t.Errorf("Expected 5 problems, got %v", problems)
+	}
+
+	// socket mode doesn't need a signing secret, but does need an app token
+	app = defaultConfig()
+	app.Email = "a@example.com"
+	app.Password = "password"
+	app.SocketMode = true
+	if problems := app.validateConfig(); len(problems) != 1 || !strings.Contains(problems[0], "app_token") {
+		t.Errorf("Expected only the app token to be missing, got %v", problems)
+	}
+}
+
+func TestCheckConfigRedactsSecrets(t *testing.T) {
+	env := testEnv(map[string]string{
+		"FIG1_EMAIL":          "a@example.com",
+		"FIG1_PASSWORD":       "hunter2",
+		"FIG1_SIGNING_SECRET": "shh",
+	})
+	path, cleanup := writeTestConfig(t, "conf.json", `{}`)
+	defer cleanup()
+
+	var out bytes.Buffer
+	code := checkConfig([]string{"-config", path}, env, &out)
+	if code != 0 {
+		t.Errorf("Expected valid config, got exit code %v:\n%v", code, out.String())
+	}
+	if strings.Contains(out.String(), "hunter2") || strings.Contains(out.String(), "shh") {
+		t.Errorf("Expected secrets to be redacted, got:\n%v", out.String())
+	}
+	if !strings.Contains(out.String(), "a@example.com") || !strings.Contains(out.String(), redacted) {
+		t.Errorf("Expected effective values to be printed, got:\n%v", out.String())
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// dedupTTL is how long deliveries are remembered, slack's last retry of an
+	// event comes 5 minutes after the first attempt
+	dedupTTL = 10 * time.Minute
+	// maxDedupKeys bounds memory if slack sends a lot at once, the oldest are forgotten first
+	maxDedupKeys = 10000
+)
+
+// dedupEntry is a key in the order it was claimed, which is also the order they expire in
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// dedupStore remembers recent slack deliveries, so retries aren't handled twice.
+// It's only kept in memory, a restart forgets everything.
+type dedupStore struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu      sync.Mutex
+	expires map[string]time.Time
+	order   []dedupEntry
+}
+
+func newDedupStore(ttl time.Duration, max int) *dedupStore {
+	return &dedupStore{
+		ttl:     ttl,
+		max:     max,
+		now:     time.Now,
+		expires: map[string]time.Time{},
+	}
+}
+
+// claim records a key, and returns false if it was already claimed within the ttl
+func (d *dedupStore) claim(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	if expires, ok := d.expires[key]; ok && now.Before(expires) {
+		return false
+	}
+
+	d.evict(now)
+	entry := dedupEntry{key, now.Add(d.ttl)}
+	d.expires[key] = entry.expires
+	d.order = append(d.order, entry)
+	return true
+}
+
+// release forgets a key, so slack's retry of it is handled
+func (d *dedupStore) release(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.expires, key)
+}
+
+// evict removes expired keys, and the oldest ones once there are too many.
+// It's called with mu locked.
+func (d *dedupStore) evict(now time.Time) {
+	for len(d.order) > 0 {
+		oldest := d.order[0]
+		if now.Before(oldest.expires) && len(d.expires) < d.max {
+			break
+		}
+		// the key might have been released and claimed again since
+		if d.expires[oldest.key] == oldest.expires {
+			delete(d.expires, oldest.key)
+		}
+		d.order = d.order[1:]
+	}
+}
+
+// deliveryKey identifies a slash command or interaction, trigger ids are unique
+// per delivery but aren't always sent
+func deliveryKey(triggerID, responseURL string) string {
+	if triggerID != "" {
+		return triggerID
+	}
+	return responseURL
+}
+
+// duplicate reports whether a delivery of kind ("command", "event" or "interaction")
+// has already been received, in which case it should be acknowledged without handling it again
+func (app *SlackApp) duplicate(ctx context.Context, kind, key string, header http.Header) bool {
+	if app.dedup == nil || key == "" || app.dedup.claim(kind+":"+key) {
+		return false
+	}
+	duplicatesTotal.Inc(kind)
+	loggerFrom(ctx).Info("Ignored duplicate delivery", "kind", kind,
+		"retry_num", header.Get("X-Slack-Retry-Num"), "retry_reason", header.Get("X-Slack-Retry-Reason"))
+	return true
+}
+
+// forgetDelivery lets slack's retry of a delivery that failed be handled
+func (app *SlackApp) forgetDelivery(kind, key string) {
+	if app.dedup != nil && key != "" {
+		app.dedup.release(kind + ":" + key)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"app/fig1"
+)
+
+func TestDedupStore(t *testing.T) {
+	now := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	d := newDedupStore(time.Minute, 2)
+	d.now = func() time.Time { return now }
+
+	if !d.claim("a") || d.claim("a") {
+		t.Errorf("Expected a to only be claimed once")
+	}
+	d.release("a")
+	if !d.claim("a") {
+		t.Errorf("Expected a released key to be claimed again")
+	}
+
+	now = now.Add(30 * time.Second)
+	d.claim("b")
+	now = now.Add(31 * time.Second)
+	if !d.claim("a") {
+		t.Errorf("Expected a to expire")
+	}
+	if d.claim("b") {
+		t.Errorf("Expected b not to have expired")
+	}
+
+	// the oldest key is forgotten once there are too many
+	d.claim("c")
+	if !d.claim("b") {
+		t.Errorf("Expected b to be evicted")
+	}
+	if len(d.expires) > 2 {
+		t.Errorf("Expected at most 2 keys, got %v", len(d.expires))
+	}
+}
+
+// holdQueue keeps the queue's only worker busy until release is called, so
+// deliveries stay queued while slack retries them
+func holdQueue(t *testing.T, app *SlackApp) (release func()) {
+	held := make(chan struct{})
+	started := make(chan struct{})
+	if !app.queue.enqueue(newJob(context.Background(), "hold", func(ctx context.Context) {
+		close(started)
+		<-held
+	})) {
+		t.Fatal("Expected the queue to accept a job")
+	}
+	<-started
+	return func() { close(held) }
+}
+
+func TestSlashCommandRetries(t *testing.T) {
+	fake := fig1.NewFake()
+	fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "Chest x-ray"}
+	app := &SlackApp{f1: fake, queue: newJobQueue(1, 1), dedup: newDedupStore(dedupTTL, maxDedupKeys)}
+
+	recorder := newResponseURLRecorder()
+	defer recorder.Close()
+	form := url.Values{
+		"channel_id":   {"C1"},
+		"user_name":    {"bob"},
+		"text":         {"case 59076d6324d11b594b2dff1d"},
+		"response_url": {recorder.URL},
+		"trigger_id":   {"123.456.abc"},
+	}
+	before, jobsBefore := duplicatesTotal.Value("command"), jobsTotal.Value(fig1Command, "done")
+	release := holdQueue(t, app)
+	for retry := 0; retry < 2; retry++ {
+		req := httptest.NewRequest("POST", fig1Command, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if retry > 0 {
+			req.Header.Set("X-Slack-Retry-Num", "1")
+			req.Header.Set("X-Slack-Retry-Reason", "http_timeout")
+		}
+		res := httptest.NewRecorder()
+		app.slashCommandHandler(res, req)
+		if res.Code != 200 {
+			t.Errorf("Expected delivery %v to be acknowledged, got %v", retry, res.Code)
+		}
+		if retry > 0 && res.Body.Len() != 0 {
+			t.Errorf("Expected an empty ack for the retry, got %v", res.Body.String())
+		}
+	}
+	release()
+	app.queue.shutdown(context.Background())
+
+	if jobs := jobsTotal.Value(fig1Command, "done") - jobsBefore; jobs != 1 {
+		t.Errorf("Expected the command to be handled once, got %v jobs", jobs)
+	}
+	if calls := fake.Calls["case"]; calls != 1 {
+		t.Errorf("Expected the case to be fetched once, got %v", calls)
+	}
+	if posts := recorder.summary(); strings.Join(posts, ",") != "in_channel,delete" {
+		t.Errorf("Expected the case to be posted once, got %v", posts)
+	}
+	if got := duplicatesTotal.Value("command") - before; got != 1 {
+		t.Errorf("Expected 1 duplicate to be counted, got %v", got)
+	}
+}
+
+func TestEventRetries(t *testing.T) {
+	app := &SlackApp{f1: fig1.NewFake(), queue: newJobQueue(1, 1), dedup: newDedupStore(dedupTTL, maxDedupKeys)}
+	app.queue.shutdown(context.Background())
+
+	deliver := func() int {
+		body := `{"type":"event_callback","event_id":"Ev1","event":{"type":"link_shared"}}`
+		res := httptest.NewRecorder()
+		app.eventsHandler(res, httptest.NewRequest("POST", "/events", strings.NewReader(body)))
+		return res.Code
+	}
+
+	// an event that couldn't be queued is handled when slack retries it
+	for retry := 0; retry < 2; retry++ {
+		if code := deliver(); code != 503 {
+			t.Errorf("Expected delivery %v to be rejected while busy, got %v", retry, code)
+		}
+	}
+
+	// an event that's already queued is only acknowledged when slack retries it
+	fake := fig1.NewFake()
+	fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "Chest x-ray"}
+	app = &SlackApp{f1: fake, queue: newJobQueue(1, 1), dedup: newDedupStore(dedupTTL, maxDedupKeys)}
+	before := jobsTotal.Value("link_shared", "done")
+	release := holdQueue(t, app)
+	for retry := 0; retry < 2; retry++ {
+		body := `{"type":"event_callback","event_id":"Ev2","team_id":"T1","event":{"type":"link_shared","channel":"C1",
+			"links":[{"url":"https://app.figure1.com/rd/image?imageid=59076d6324d11b594b2dff1d"}]}}`
+		req := httptest.NewRequest("POST", "/events", strings.NewReader(body))
+		if retry > 0 {
+			req.Header.Set("X-Slack-Retry-Num", "1")
+			req.Header.Set("X-Slack-Retry-Reason", "http_timeout")
+		}
+		res := httptest.NewRecorder()
+		app.eventsHandler(res, req)
+		if res.Code != 200 {
+			t.Errorf("Expected delivery %v to be acknowledged, got %v", retry, res.Code)
+		}
+	}
+	release()
+	app.queue.shutdown(context.Background())
+
+	if jobs := jobsTotal.Value("link_shared", "done") - before; jobs != 1 {
+		t.Errorf("Expected the event to be handled once, got %v jobs", jobs)
+	}
+	if calls := fake.Calls["case"]; calls != 1 {
+		t.Errorf("Expected the link to be unfurled once, got %v calls", calls)
+	}
+}
+
+func TestInteractionRetries(t *testing.T) {
+	fake := fig1.NewFake()
+	fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "Chest x-ray"}
+	app := &SlackApp{f1: fake, queue: newJobQueue(1, 1), dedup: newDedupStore(dedupTTL, maxDedupKeys)}
+
+	recorder := newResponseURLRecorder()
+	defer recorder.Close()
+	ref := previewRef{contentCase, "59076d6324d11b594b2dff1d", "bob", "U1"}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"trigger_id":   "123.456.def",
+		"response_url": recorder.URL,
+		"team":         map[string]string{"id": "T1"},
+		"user":         map[string]string{"id": "U1"},
+		"channel":      map[string]string{"id": "C1"},
+		"actions":      []map[string]string{{"action_id": actionRefresh, "value": ref.String()}},
+	})
+	form := url.Values{"payload": {string(payload)}}
+
+	before, jobsBefore := duplicatesTotal.Value("interaction"), jobsTotal.Value("block_actions", "done")
+	release := holdQueue(t, app)
+	for retry := 0; retry < 2; retry++ {
+		req := httptest.NewRequest("POST", "/interactions", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if retry > 0 {
+			req.Header.Set("X-Slack-Retry-Num", "1")
+			req.Header.Set("X-Slack-Retry-Reason", "http_timeout")
+		}
+		res := httptest.NewRecorder()
+		app.interactionsHandler(res, req)
+		if res.Code != 200 {
+			t.Errorf("Expected delivery %v to be acknowledged, got %v", retry, res.Code)
+		}
+	}
+	release()
+	app.queue.shutdown(context.Background())
+
+	if jobs := jobsTotal.Value("block_actions", "done") - jobsBefore; jobs != 1 {
+		t.Errorf("Expected the click to be handled once, got %v jobs", jobs)
+	}
+	if posts := recorder.summary(); strings.Join(posts, ",") != "in_channel replace" {
+		t.Errorf("Expected the message to be refreshed once, got %v", posts)
+	}
+	if got := duplicatesTotal.Value("interaction") - before; got != 1 {
+		t.Errorf("Expected 1 duplicate to be counted, got %v", got)
+	}
+}
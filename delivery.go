@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"app/logging"
+	"app/slackapi"
+)
+
+const (
+	// maxDeadLetters is how many failed deliveries are kept, oldest are dropped first
+	maxDeadLetters = 500
+	// responseURLTimeout is how long each post to a response_url can take, so one
+	// that never answers is retried instead of holding on until the job's deadline
+	responseURLTimeout = 10 * time.Second
+)
+
+// retryPolicy is how often and how far apart a delivery is attempted
+type retryPolicy struct {
+	// attempts is 0 to keep trying until ctx's deadline
+	attempts   int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+var (
+	// responseURLRetries are made by the job's worker, they only take a few seconds
+	// so a slack outage doesn't hold on to every worker
+	responseURLRetries = retryPolicy{attempts: 3, minBackoff: 500 * time.Millisecond, maxBackoff: 30 * time.Second}
+	// backgroundRetries carry on from there until the response_url expires
+	backgroundRetries = retryPolicy{minBackoff: 5 * time.Second, maxBackoff: time.Minute}
+)
+
+// responseURLClient is shared by every post to a response_url
+var responseURLClient = &http.Client{Timeout: responseURLTimeout}
+
+// retryingDeliveries are the deliveries being retried in the background, see deliverResponse
+var retryingDeliveries = &backgroundDeliveries{}
+
+// backoff is exponential with jitter, so retries from many jobs don't line up
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.minBackoff << uint(attempt-1)
+	if d > p.maxBackoff || d <= 0 {
+		d = p.maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// deliveryError is a failed post to a response_url
+type deliveryError struct {
+	status     int // 0 if there wasn't a response
+	body       string
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *deliveryError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("slack responded with %v: %v", e.status, e.body)
+}
+
+// retryable is true for network errors, rate limits and slack errors; other
+// 4xx such as `expired_url` or `used_url` won't get better by retrying
+func (e *deliveryError) retryable() bool {
+	return e.status == 0 || e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+func isRetryable(err error) bool {
+	de, ok := err.(*deliveryError)
+	return ok && de.retryable()
+}
+
+// parseRetryAfter reads a `Retry-After` header, which is either seconds or a date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// responseTarget is where to answer a slash command or interaction. The channel
+// and user are used with the bot token if the response_url doesn't work.
+type responseTarget struct {
+	URL     string
+	Team    string // picks the bot token
+	Channel string
+	User    string // only needed for ephemeral messages
+}
+
+// fallbackFunc posts a message some other way when its response_url doesn't work
+type fallbackFunc func(ctx context.Context, to responseTarget, data []byte) error
+
+// postToResponseURL sends a message to a slash command or interaction `response_url`,
+// retrying until it's delivered or the response_url expires. It returns once the
+// message is delivered, or is being retried in the background. Messages that can't
+// be delivered are kept in `deadLetters` for an admin to look at.
+func postToResponseURL(ctx context.Context, link string, body *SlackResponse) error {
+	return deliverResponse(ctx, responseTarget{URL: link}, body, nil)
+}
+
+// respond is postToResponseURL, but posts to the channel with the web api if the
+// response_url doesn't work, so content isn't lost when slack has problems
+func (app *SlackApp) respond(ctx context.Context, to responseTarget, body *SlackResponse) error {
+	return deliverResponse(ctx, to, body, app.postToChannel)
+}
+
+// deliverResponse makes a few attempts, then tries the fallback. If that doesn't
+// work either, retryable errors are retried in the background until ctx's deadline.
+func deliverResponse(ctx context.Context, to responseTarget, body *SlackResponse, fallback fallbackFunc) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	log := loggerFrom(ctx)
+	attempts, err := deliver(ctx, responseURLRetries, to.URL, data)
+	if err == nil {
+		return nil
+	}
+	if fallback != nil {
+		// ctx may have run out with the response_url
+		switch fallbackErr := fallback(logging.NewContext(context.Background(), log), to, data); fallbackErr {
+		case nil:
+			log.Warn("Posted with the web api instead of the response_url", "channel", to.Channel, "err", err)
+			return nil
+		case errNoFallback:
+		default:
+			log.Error("Failed to post with the web api", "channel", to.Channel, "err", fallbackErr)
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(responseURLValidity)
+	}
+	if isRetryable(err) && time.Now().Add(backgroundRetries.minBackoff).Before(deadline) {
+		log.Warn("Retrying response_url delivery in the background", "attempts", attempts, "err", err)
+		retryingDeliveries.start(logging.NewContext(context.Background(), log), deadline, to, data, attempts)
+		return nil
+	}
+
+	giveUp(ctx, to, data, attempts, err)
+	return err
+}
+
+// giveUp keeps a message that couldn't be delivered as a dead letter
+func giveUp(ctx context.Context, to responseTarget, data []byte, attempts int, err error) {
+	letter := deadLetters.add(ctx, to, data, attempts, err)
+	deadLettersTotal.Inc(strconv.FormatBool(isRetryable(err)))
+	loggerFrom(ctx).Error("Gave up delivering to response_url", "dead_letter", letter.ID, "attempts", attempts, "err", err)
+}
+
+// backgroundDeliveries retries response_url deliveries without holding on to a job's worker
+type backgroundDeliveries struct {
+	wg      sync.WaitGroup
+	running int32
+}
+
+// start keeps retrying until the deadline, then gives up with a dead letter
+func (b *backgroundDeliveries) start(ctx context.Context, deadline time.Time, to responseTarget, data []byte, attempts int) {
+	b.wg.Add(1)
+	atomic.AddInt32(&b.running, 1)
+	go func() {
+		defer b.wg.Done()
+		defer atomic.AddInt32(&b.running, -1)
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+
+		// the worker's attempts have already waited out the first backoffs
+		wait := backgroundRetries.backoff(1)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+		more, err := deliver(ctx, backgroundRetries, to.URL, data)
+		if err != nil {
+			giveUp(ctx, to, data, attempts+more, err)
+			return
+		}
+		loggerFrom(ctx).Info("Delivered to response_url in the background", "attempts", attempts+more)
+	}()
+}
+
+func (b *backgroundDeliveries) count() int {
+	return int(atomic.LoadInt32(&b.running))
+}
+
+// wait waits for the deliveries that are being retried, or until ctx is done
+func (b *backgroundDeliveries) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var errNoFallback = errors.New("message can only be sent to a response_url")
+
+// postToChannel posts a response_url message with the bot token instead. Messages that
+// delete or replace a message everyone can see can't be, since there's no way to tell
+// which one it was, but ephemeral replacements are posted as new ephemeral messages.
+func (app *SlackApp) postToChannel(ctx context.Context, to responseTarget, data []byte) error {
+	var body struct {
+		ResponseType    string          `json:"response_type"`
+		ReplaceOriginal bool            `json:"replace_original"`
+		DeleteOriginal  bool            `json:"delete_original"`
+		Text            string          `json:"text"`
+		Attachments     json.RawMessage `json:"attachments"`
+		Blocks          json.RawMessage `json:"blocks"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+	client := app.slackFor(to.Team)
+	if client == nil || to.Channel == "" || body.DeleteOriginal {
+		return errNoFallback
+	}
+
+	msg := &slackapi.Message{Channel: to.Channel, Text: body.Text}
+	if len(body.Attachments) > 0 {
+		msg.Attachments = body.Attachments
+	}
+	if len(body.Blocks) > 0 {
+		msg.Blocks = body.Blocks
+	}
+	if body.ResponseType == "in_channel" {
+		if body.ReplaceOriginal {
+			return errNoFallback
+		}
+		_, err := client.PostMessage(ctx, msg)
+		return err
+	}
+	if to.User == "" {
+		return errNoFallback
+	}
+	msg.User = to.User
+	return client.PostEphemeral(ctx, msg)
+}
+
+// deliver posts data to link until it succeeds, a permanent error, or there's
+// no time left before ctx's deadline, returning the number of attempts made
+func deliver(ctx context.Context, policy retryPolicy, link string, data []byte) (int, error) {
+	log := loggerFrom(ctx)
+	for attempt := 1; ; attempt++ {
+		err := postJSON(ctx, link, data)
+		if err == nil {
+			return attempt, nil
+		}
+		if !err.retryable() || (policy.attempts > 0 && attempt >= policy.attempts) {
+			return attempt, err
+		}
+
+		wait := policy.backoff(attempt)
+		if err.retryAfter > wait {
+			wait = err.retryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return attempt, err
+		}
+
+		log.Warn("Retrying response_url delivery", "attempt", attempt, "wait", wait, "err", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return attempt, err
+		}
+	}
+}
+
+func postJSON(ctx context.Context, link string, data []byte) *deliveryError {
+	req, err := http.NewRequest("POST", link, bytes.NewReader(data))
+	if err != nil {
+		return &deliveryError{err: err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := responseURLClient.Do(req)
+	if err != nil {
+		responseURLDeliveries.Inc("0")
+		return &deliveryError{err: err}
+	}
+	defer resp.Body.Close()
+	responseURLDeliveries.Inc(strconv.Itoa(resp.StatusCode))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	return &deliveryError{
+		status:     resp.StatusCode,
+		body:       string(respBody),
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// deadLetter is a message that couldn't be delivered to a response_url
+type deadLetter struct {
+	ID        string          `json:"id"`
+	RequestID string          `json:"request_id,omitempty"`
+	URL       string          `json:"url"`
+	Team      string          `json:"team,omitempty"`
+	Channel   string          `json:"channel,omitempty"`
+	User      string          `json:"user,omitempty"`
+	Body      json.RawMessage `json:"body"`
+	Attempts  int             `json:"attempts"`
+	Error     string          `json:"error"`
+	FailedAt  time.Time       `json:"failed_at"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// deadLetterStore keeps the most recent failed deliveries in memory, since
+// response_urls expire after 30 minutes there's no point keeping them longer
+type deadLetterStore struct {
+	mu      sync.Mutex
+	size    int
+	letters []*deadLetter // oldest first
+}
+
+// deadLetters are failed response_url deliveries, see `/admin/dead-letters`
+var deadLetters = newDeadLetterStore(maxDeadLetters)
+
+func newDeadLetterStore(size int) *deadLetterStore {
+	return &deadLetterStore{size: size}
+}
+
+func (s *deadLetterStore) add(ctx context.Context, to responseTarget, data []byte, attempts int, err error) *deadLetter {
+	now := time.Now()
+	expires, ok := ctx.Deadline()
+	if !ok {
+		expires = now.Add(responseURLValidity)
+	}
+	letter := &deadLetter{
+		ID:        logging.NewRequestID(),
+		RequestID: logging.RequestID(ctx),
+		URL:       to.URL,
+		Team:      to.Team,
+		Channel:   to.Channel,
+		User:      to.User,
+		Body:      json.RawMessage(data),
+		Attempts:  attempts,
+		Error:     err.Error(),
+		FailedAt:  now,
+		ExpiresAt: expires,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters = append(s.letters, letter)
+	if len(s.letters) > s.size {
+		s.letters = s.letters[len(s.letters)-s.size:]
+	}
+	return letter
+}
+
+// list copies the dead letters, since replaying updates them
+func (s *deadLetterStore) list() []deadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letters := make([]deadLetter, len(s.letters))
+	for i, letter := range s.letters {
+		letters[i] = *letter
+	}
+	return letters
+}
+
+func (s *deadLetterStore) get(id string) (deadLetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, letter := range s.letters {
+		if letter.ID == id {
+			return *letter, true
+		}
+	}
+	return deadLetter{}, false
+}
+
+func (s *deadLetterStore) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, letter := range s.letters {
+		if letter.ID == id {
+			s.letters = append(s.letters[:i], s.letters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *deadLetterStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.letters)
+}
+
+var errResponseURLExpired = errors.New("response_url has expired")
+
+// replay tries to deliver a dead letter again, it's removed if that works. Once
+// the response_url has expired it can only be posted with fallback.
+func (s *deadLetterStore) replay(ctx context.Context, letter deadLetter, fallback fallbackFunc) error {
+	to := responseTarget{URL: letter.URL, Team: letter.Team, Channel: letter.Channel, User: letter.User}
+
+	err := errResponseURLExpired
+	if time.Now().Before(letter.ExpiresAt) {
+		urlCtx, cancel := context.WithDeadline(ctx, letter.ExpiresAt)
+		// an admin is waiting on this, so only try once
+		_, err = deliver(urlCtx, retryPolicy{attempts: 1}, letter.URL, letter.Body)
+		cancel()
+	}
+	if err != nil && fallback != nil {
+		if fallbackErr := fallback(ctx, to, letter.Body); fallbackErr != errNoFallback {
+			err = fallbackErr
+		}
+	}
+
+	if err == errResponseURLExpired {
+		return err
+	}
+	if err != nil {
+		s.mu.Lock()
+		for _, stored := range s.letters {
+			if stored.ID == letter.ID {
+				stored.Attempts++
+				stored.Error = err.Error()
+			}
+		}
+		s.mu.Unlock()
+		return err
+	}
+	s.remove(letter.ID)
+	return nil
+}
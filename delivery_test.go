@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"app/slackapi"
+)
+
+var fastRetries = retryPolicy{attempts: 4, minBackoff: time.Millisecond, maxBackoff: 5 * time.Millisecond}
+
+// slackServer responds with each status in turn, then keeps responding with the last one
+func slackServer(statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		res.WriteHeader(statuses[n-1])
+	}))
+	return server, &calls
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		ok       bool
+	}{
+		{"delivered", []int{200}, 1, true},
+		{"slack errors", []int{503, 500, 200}, 3, true},
+		{"rate limited", []int{429, 200}, 2, true},
+		{"expired url", []int{404}, 1, false},
+		{"slack down", []int{503}, 4, false},
+	}
+
+	for _, test := range tests {
+		server, calls := slackServer(test.statuses...)
+		attempts, err := deliver(context.Background(), fastRetries, server.URL, []byte(`{}`))
+		server.Close()
+
+		if (err == nil) != test.ok || attempts != test.attempts || int(*calls) != test.attempts {
+			t.Errorf("Expected %v to take %v attempts (ok: %v), got %v attempts, %v calls (err: %v)", test.name, test.attempts, test.ok, attempts, *calls, err)
+		}
+	}
+}
+
+func TestDeliverStopsAtDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Retry-After", "60")
+		res.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	// waiting a minute would be past the response_url's expiry
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+	attempts, err := deliver(ctx, fastRetries, server.URL, []byte(`{}`))
+	if err == nil || attempts != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected to give up without waiting past the deadline, got %v attempts in %v (err: %v)", attempts, time.Since(start), err)
+	}
+}
+
+func TestDeliverTimesOut(t *testing.T) {
+	defer func(client *http.Client) { responseURLClient = client }(responseURLClient)
+	responseURLClient = &http.Client{Timeout: 20 * time.Millisecond}
+
+	// a response_url that never answers is retried, not waited on until the job's deadline
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		<-hang
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	start := time.Now()
+	attempts, err := deliver(ctx, fastRetries, server.URL, []byte(`{}`))
+	if !isRetryable(err) || attempts != fastRetries.attempts || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected each attempt to time out, got %v attempts in %v (err: %v)", attempts, time.Since(start), err)
+	}
+}
+
+func TestDeliverUntilDeadline(t *testing.T) {
+	server, calls := slackServer(503, 503, 503, 503, 503, 503, 503, 200)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	untilDeadline := retryPolicy{minBackoff: time.Millisecond, maxBackoff: 5 * time.Millisecond}
+	if attempts, err := deliver(ctx, untilDeadline, server.URL, []byte(`{}`)); err != nil || attempts != 8 || *calls != 8 {
+		t.Errorf("Expected to keep retrying until delivered, got %v attempts (err: %v)", attempts, err)
+	}
+}
+
+func TestRetriesInBackground(t *testing.T) {
+	defer func(inline, background retryPolicy) {
+		responseURLRetries, backgroundRetries = inline, background
+	}(responseURLRetries, backgroundRetries)
+	responseURLRetries = retryPolicy{attempts: 2, minBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+	backgroundRetries = retryPolicy{minBackoff: time.Millisecond, maxBackoff: 5 * time.Millisecond}
+
+	// slack is down for longer than the worker retries for, the rest happen in the background
+	server, calls := slackServer(503, 503, 503, 503, 200)
+	defer server.Close()
+	before := deadLetters.count()
+	start := time.Now()
+	if err := postToResponseURL(context.Background(), server.URL, &SlackResponse{Text: "hi"}); err != nil {
+		t.Errorf("Expected the delivery to be retried in the background, got %v", err)
+	}
+	if atomic.LoadInt32(calls) != 2 || time.Since(start) > time.Second {
+		t.Errorf("Expected the worker to only make 2 attempts, got %v in %v", atomic.LoadInt32(calls), time.Since(start))
+	}
+	retryingDeliveries.wait(context.Background())
+	if atomic.LoadInt32(calls) != 5 || deadLetters.count() != before {
+		t.Errorf("Expected the background retries to deliver it, got %v calls and %v new dead letters", atomic.LoadInt32(calls), deadLetters.count()-before)
+	}
+
+	// they stop when the response_url expires
+	down, _ := slackServer(503)
+	defer down.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	postToResponseURL(ctx, down.URL, &SlackResponse{Text: "hi"})
+	retryingDeliveries.wait(context.Background())
+	if deadLetters.count() != before+1 || retryingDeliveries.count() != 0 {
+		t.Errorf("Expected a dead letter once the response_url expired, got %v", deadLetters.count()-before)
+	}
+
+	// errors that won't get better aren't retried
+	gone, goneCalls := slackServer(404)
+	defer gone.Close()
+	if err := postToResponseURL(context.Background(), gone.URL, &SlackResponse{Text: "hi"}); err == nil || atomic.LoadInt32(goneCalls) != 1 {
+		t.Errorf("Expected an expired url to fail straight away, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"Thu, 01 Mar 2018 12:01:00 GMT", time.Minute},
+		{"Thu, 01 Mar 2018 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, test := range tests {
+		if got := parseRetryAfter(test.value, now); got != test.expected {
+			t.Errorf("Expected %q to be %v, got %v", test.value, test.expected, got)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt < 10; attempt++ {
+		d := responseURLRetries.backoff(attempt)
+		if d < responseURLRetries.minBackoff/2 || d > responseURLRetries.maxBackoff {
+			t.Errorf("Expected backoff for attempt %v to be between %v and %v, got %v", attempt, responseURLRetries.minBackoff/2, responseURLRetries.maxBackoff, d)
+		}
+	}
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	store := newDeadLetterStore(2)
+	server, calls := slackServer(500, 200)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := deliver(ctx, retryPolicy{attempts: 1}, server.URL, []byte(`{"text":"hi"}`))
+	if err == nil {
+		t.Fatalf("Expected first delivery to fail")
+	}
+	letter := store.add(ctx, responseTarget{URL: server.URL}, []byte(`{"text":"hi"}`), 1, err)
+	if !strings.Contains(letter.Error, "500") {
+		t.Errorf("Expected the dead letter to have the error, got %q", letter.Error)
+	}
+
+	if err := store.replay(context.Background(), *letter, nil); err != nil || store.count() != 0 || *calls != 2 {
+		t.Errorf("Expected replay to deliver and remove the dead letter, got %v letters, %v calls (err: %v)", store.count(), *calls, err)
+	}
+
+	expired := store.add(context.Background(), responseTarget{URL: server.URL}, []byte(`{}`), 1, err)
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	if err := store.replay(context.Background(), *expired, nil); err != errResponseURLExpired || *calls != 2 {
+		t.Errorf("Expected expired dead letters not to be sent, got %v", err)
+	}
+
+	// only the most recent are kept
+	store.add(context.Background(), responseTarget{URL: server.URL}, []byte(`{}`), 1, err)
+	last := store.add(context.Background(), responseTarget{URL: server.URL}, []byte(`{}`), 1, err)
+	if letters := store.list(); len(letters) != 2 || letters[1].ID != last.ID {
+		t.Errorf("Expected the 2 most recent dead letters, got %v", letters)
+	}
+}
+
+func TestRespondFallsBackToWebAPI(t *testing.T) {
+	responseURL, _ := slackServer(404)
+	defer responseURL.Close()
+
+	posted := make(chan slackapi.Message, 10)
+	api := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var msg slackapi.Message
+		json.NewDecoder(req.Body).Decode(&msg)
+		posted <- msg
+		json.NewEncoder(res).Encode(slackapi.Response{OK: true})
+	}))
+	defer api.Close()
+
+	app := &SlackApp{OAuthAccessToken: "xoxb-test"}
+	app.slack = slackapi.New(slackapi.Config{Token: app.OAuthAccessToken, BaseURL: api.URL})
+	to := responseTarget{URL: responseURL.URL, Channel: "C1", User: "U1"}
+
+	before := deadLetters.count()
+	if err := app.respond(context.Background(), to, &SlackResponse{ResponseType: "in_channel", Text: "case"}); err != nil {
+		t.Errorf("Expected the message to be posted to the channel, got %v", err)
+	}
+	if msg := <-posted; msg.Channel != "C1" || msg.User != "" || msg.Text != "case" {
+		t.Errorf("Expected chat.postMessage to C1, got %+v", msg)
+	}
+	if err := app.respond(context.Background(), to, &SlackResponse{ResponseType: "ephemeral", Text: "only you"}); err != nil {
+		t.Errorf("Expected the ephemeral message to be posted, got %v", err)
+	}
+	if msg := <-posted; msg.User != "U1" {
+		t.Errorf("Expected chat.postEphemeral to U1, got %+v", msg)
+	}
+	if deadLetters.count() != before {
+		t.Errorf("Expected no dead letters when the fallback works")
+	}
+
+	// there's no way to replace a message without its response_url
+	if err := app.respond(context.Background(), to, &SlackResponse{DeleteOriginal: true}); err == nil || len(posted) != 0 {
+		t.Errorf("Expected deleting to fail without posting, got %v", err)
+	}
+	if deadLetters.count() != before+1 {
+		t.Errorf("Expected a dead letter when there's no fallback")
+	}
+
+	// expired dead letters can still be posted to the channel
+	store := newDeadLetterStore(10)
+	letter := store.add(context.Background(), to, []byte(`{"response_type":"in_channel","text":"late"}`), 1, &deliveryError{status: 500})
+	letter.ExpiresAt = time.Now().Add(-time.Second)
+	if err := store.replay(context.Background(), *letter, app.postToChannel); err != nil || store.count() != 0 {
+		t.Errorf("Expected the expired dead letter to be posted to the channel, got %v", err)
+	}
+	if msg := <-posted; msg.Text != "late" {
+		t.Errorf("Expected the dead letter's text, got %+v", msg)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// eventRequestBody is the outer envelope slack sends to the events endpoint
+type eventRequestBody struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	TeamID    string `json:"team_id"`
+	EventID   string `json:"event_id"`
+	Event     struct {
+		Type      string `json:"type"`
+		User      string `json:"user"`
+		Channel   string `json:"channel"`
+		MessageTS string `json:"message_ts"`
+		UnfurlID  string `json:"unfurl_id"`
+		Source    string `json:"source"`
+		Links     []struct {
+			Domain string `json:"domain"`
+			URL    string `json:"url"`
+		} `json:"links"`
+	} `json:"event"`
+}
+
+type unfurlRequestBody struct {
+	Channel  string                 `json:"channel,omitempty"`
+	TS       string                 `json:"ts,omitempty"`
+	UnfurlID string                 `json:"unfurl_id,omitempty"`
+	Source   string                 `json:"source,omitempty"`
+	Unfurls  map[string]interface{} `json:"unfurls"`
+}
+
+func (app *SlackApp) eventsHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body eventRequestBody
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		msg := "Failed to parse event body"
+		http.Error(res, msg, http.StatusBadRequest)
+		loggerFrom(req.Context()).Error(msg, "err", err)
+		return
+	}
+
+	switch body.Type {
+	case "url_verification":
+		// slack checks the endpoint is ours when the request url is saved
+		res.Header().Set("Content-Type", "text/plain")
+		res.Write([]byte(body.Challenge))
+	case "event_callback":
+		// slack retries events it doesn't get an ack for in time, even if they were handled
+		if app.duplicate(req.Context(), "event", body.EventID, req.Header) {
+			res.WriteHeader(http.StatusOK)
+			return
+		}
+
+		switch body.Event.Type {
+		case "link_shared":
+		case "app_uninstalled", "tokens_revoked":
+			if err := app.uninstall(body.TeamID); err != nil {
+				loggerFrom(req.Context()).Error("Failed to remove installation", "team", body.TeamID, "err", err)
+				app.forgetDelivery("event", body.EventID)
+				http.Error(res, "Failed to remove installation", http.StatusInternalServerError)
+				return
+			}
+			loggerFrom(req.Context()).Info("Uninstalled", "team", body.TeamID, "event", body.Event.Type)
+			res.WriteHeader(http.StatusOK)
+			return
+		default:
+			res.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// slack expects an ack within 3 seconds, so do the actual work afterwards
+		queued := app.queue.enqueue(newJob(req.Context(), "link_shared", func(ctx context.Context) {
+			app.handleLinkShared(ctx, &body)
+		}))
+		if !queued {
+			// slack retries events that fail, which is exactly what we want
+			app.forgetDelivery("event", body.EventID)
+			http.Error(res, busyMessage, http.StatusServiceUnavailable)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	default:
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+func (app *SlackApp) handleLinkShared(ctx context.Context, body *eventRequestBody) {
+	unfurls := map[string]interface{}{}
+	for _, link := range body.Event.Links {
+		kind, id := parseFig1Link(link.URL)
+		if kind == "" {
+			continue
+		}
+
+		content, err := app.fetchContent(ctx, body.TeamID, kind, id, "")
+		if err != nil {
+			loggerFrom(ctx).Error("Failed to unfurl", "kind", kind, "link", link.URL, "err", err)
+			continue
+		}
+		unfurls[link.URL] = unfurlContent(content)
+	}
+
+	if len(unfurls) == 0 {
+		return
+	}
+	client := app.slackFor(body.TeamID)
+	if client == nil {
+		loggerFrom(ctx).Warn("Can't unfurl links without a bot token", "team", body.TeamID)
+		return
+	}
+
+	reqBody := &unfurlRequestBody{
+		Channel:  body.Event.Channel,
+		TS:       body.Event.MessageTS,
+		UnfurlID: body.Event.UnfurlID,
+		Source:   body.Event.Source,
+		Unfurls:  unfurls,
+	}
+	if err := client.Call(ctx, "chat.unfurl", reqBody, nil); err != nil {
+		loggerFrom(ctx).Error("Failed to unfurl links", "channel", body.Event.Channel, "err", err)
+	}
+}
+
+// fetchContent retrieves and renders figure 1 content by type with the workspace's account,
+// never a user's own session, since unfurls and refreshes are seen by the whole channel
+func (app *SlackApp) fetchContent(ctx context.Context, teamID, kind, id, opUser string) (*SlackResponse, error) {
+	r := app.renderer(teamID)
+	f1 := app.workspaceFigure1(teamID)
+	switch kind {
+	case contentCase:
+		f1Case, err := f1.Case(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return r.renderCase(f1Case, opUser), nil
+	case contentUser:
+		f1User, err := f1.User(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return r.renderUser(f1User, opUser), nil
+	case contentCollection:
+		f1Collection, err := f1.Collection(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return r.renderCollection(f1Collection, opUser), nil
+	}
+	return nil, fmt.Errorf("unknown content type %v", kind)
+}
+
+// unfurlContent converts a rendered preview into an unfurl, block kit unfurls
+// can be used as is but legacy ones only take a single attachment
+func unfurlContent(content *SlackResponse) interface{} {
+	if len(content.Blocks) > 0 {
+		return struct {
+			Blocks []Block `json:"blocks"`
+		}{content.Blocks}
+	}
+	return flattenAttachments(content.Attachments)
+}
+
+// flattenAttachments folds the sections of a preview into one attachment,
+// since unfurls only take a single attachment per link
+func flattenAttachments(attachments []*Attachment) *Attachment {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	combined := *attachments[0]
+	combined.Fields = append([]*Field{}, combined.Fields...)
+	for _, a := range attachments[1:] {
+		if combined.ThumbURL == "" {
+			combined.ThumbURL = a.ThumbURL
+		}
+		if combined.Fallback == "" {
+			combined.Fallback = a.Fallback
+		}
+
+		value := strings.TrimSpace(a.Text + "\n" + a.Footer)
+		if a.Title != "" || value != "" {
+			combined.Fields = append(combined.Fields, &Field{Title: a.Title, Value: value})
+		}
+		combined.Fields = append(combined.Fields, a.Fields...)
+	}
+	return &combined
+}
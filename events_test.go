@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"app/fig1"
+)
+
+func TestFetchContent(t *testing.T) {
+	fake := fig1.NewFake()
+	fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "Chest x-ray"}
+	fake.Users["ccovic"] = &fig1.User{Username: "ccovic", Category: "Physician", Specialty: "Cardiology"}
+
+	app := &SlackApp{
+		f1:         fake,
+		Workspaces: map[string]workspaceSettings{"TBLOCKS": {MessageFormat: formatBlocks}},
+	}
+
+	content, err := app.fetchContent(context.Background(), "T1", contentCase, "59076d6324d11b594b2dff1d", "bob")
+	if err != nil {
+		t.Fatalf("Expected case content, got %v", err)
+	}
+	if len(content.Attachments) == 0 || len(content.Blocks) != 0 {
+		t.Errorf("Expected legacy attachments by default, got %+v", content)
+	}
+	if unfurl, ok := unfurlContent(content).(*Attachment); !ok || !strings.Contains(unfurl.Fallback, "Chest x-ray") {
+		t.Errorf("Expected legacy unfurl to be a single attachment, got %+v", unfurlContent(content))
+	}
+
+	content, err = app.fetchContent(context.Background(), "TBLOCKS", contentUser, "ccovic", "")
+	if err != nil {
+		t.Fatalf("Expected user content, got %v", err)
+	}
+	if len(content.Blocks) == 0 || len(content.Attachments) != 0 {
+		t.Errorf("Expected blocks for workspace configured with blocks, got %+v", content)
+	}
+
+	if _, err := app.fetchContent(context.Background(), "T1", contentCollection, "5907549889c89eef5b1b3511", ""); !fig1.IsNotFound(err) {
+		t.Errorf("Expected NotFound for missing collection, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+// Package fig1 is a client for the (private) figure 1 api
+package fig1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"app/logging"
+)
+
+const (
+	// DefaultAppURL serves cases, profiles and login
+	DefaultAppURL = "https://app.figure1.com"
+	// DefaultAPIURL serves collections
+	DefaultAPIURL = "https://api.figure1.com"
+)
+
+// Client retrieves content from figure 1
+type Client interface {
+	Case(ctx context.Context, id string) (*Case, error)
+	User(ctx context.Context, username string) (*User, error)
+	Collection(ctx context.Context, id string) (*Collection, error)
+	// Login fetches a new bearer token using the configured credentials
+	Login(ctx context.Context) error
+}
+
+// Config is everything needed to create an HTTPClient
+type Config struct {
+	Email    string
+	Password string
+
+	// base urls, without a trailing slash
+	AppURL string
+	APIURL string
+
+	// HTTPClient is shared between all requests, defaults to a client with a 30 second timeout
+	HTTPClient *http.Client
+
+	// Token, if set, is used until it's rejected or expires, eg: a user's own session.
+	// Without an email and password it can't be replaced, see ErrSessionExpired.
+	Token string
+
+	// RefreshBefore is how long before a JWT bearer token expires to replace it
+	RefreshBefore time.Duration
+
+	// OnLogin, if set, is called with the result of every login attempt
+	OnLogin func(err error)
+
+	// OnRequest, if set, is called after every request to figure 1 with the
+	// endpoint ("case", "user", "collection" or "login") and the response status,
+	// which is 0 if there wasn't a response
+	OnRequest func(ctx context.Context, endpoint string, status int, elapsed time.Duration)
+}
+
+// HTTPClient talks to the real figure 1 api
+type HTTPClient struct {
+	email    string
+	password string
+	appURL   string
+	apiURL   string
+	http     *http.Client
+
+	tokens    *tokenManager
+	onRequest func(ctx context.Context, endpoint string, status int, elapsed time.Duration)
+}
+
+// New creates a client, call Login before making any requests
+func New(conf Config) *HTTPClient {
+	c := &HTTPClient{
+		email:    conf.Email,
+		password: conf.Password,
+		appURL:   strings.TrimSuffix(conf.AppURL, "/"),
+		apiURL:   strings.TrimSuffix(conf.APIURL, "/"),
+		http:     conf.HTTPClient,
+
+		onRequest: conf.OnRequest,
+	}
+	if c.appURL == "" {
+		c.appURL = DefaultAppURL
+	}
+	if c.apiURL == "" {
+		c.apiURL = DefaultAPIURL
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 30 * time.Second}
+	}
+	c.tokens = newTokenManager(c.login, conf.RefreshBefore)
+	c.tokens.onLogin = conf.OnLogin
+	if conf.Token != "" {
+		c.tokens.set(conf.Token)
+	}
+	return c
+}
+
+// Case retrieves a case by id
+func (c *HTTPClient) Case(ctx context.Context, id string) (*Case, error) {
+	segment, err := pathSegment(id)
+	if err != nil {
+		return nil, err
+	}
+	var body Case
+	if err := c.get(ctx, "case", c.appURL+"/s/case/"+segment, &body); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// User retrieves a public profile by username
+func (c *HTTPClient) User(ctx context.Context, username string) (*User, error) {
+	segment, err := pathSegment(username)
+	if err != nil {
+		return nil, err
+	}
+	var body User
+	if err := c.get(ctx, "user", c.appURL+"/s/profile/public/"+segment, &body); err != nil {
+		return nil, err
+	}
+
+	body.Category = body.SpecialtyObject.Category.Strings.Label
+	body.Specialty = body.SpecialtyObject.Strings.Label
+
+	return &body, nil
+}
+
+// Collection retrieves a collection by id
+func (c *HTTPClient) Collection(ctx context.Context, id string) (*Collection, error) {
+	segment, err := pathSegment(id)
+	if err != nil {
+		return nil, err
+	}
+	var body Collection
+	if err := c.get(ctx, "collection", c.apiURL+"/collections/"+segment, &body); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// pathSegment escapes an id or username so it can only be a single path segment.
+// Empty and dot segments would still change the endpoint, so they're NotFound.
+func pathSegment(value string) (string, error) {
+	if value == "" || value == "." || value == ".." {
+		return "", &Error{Kind: NotFound, Err: fmt.Errorf("invalid id %q", value)}
+	}
+	return url.PathEscape(value), nil
+}
+
+func (c *HTTPClient) get(ctx context.Context, endpoint, url string, out interface{}) error {
+	token, version, err := c.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(ctx, endpoint, url, token)
+	if err != nil {
+		return err
+	}
+
+	// token was rejected, log in again (or wait for whoever already is) and retry once
+	if res.StatusCode == http.StatusUnauthorized {
+		res.Body.Close()
+		if token, _, err = c.tokens.Invalidate(ctx, version); err != nil {
+			return err
+		}
+		if res, err = c.do(ctx, endpoint, url, token); err != nil {
+			return err
+		}
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		return &Error{Kind: Unauthorized, Status: res.StatusCode, URL: url}
+	case res.StatusCode == http.StatusNotFound:
+		return &Error{Kind: NotFound, Status: res.StatusCode, URL: url}
+	case res.StatusCode != http.StatusOK:
+		return &Error{Kind: Upstream, Status: res.StatusCode, URL: url}
+	}
+
+	if err := decode(res.Body, out); err != nil {
+		return &Error{Kind: Upstream, Status: res.StatusCode, URL: url, Err: err}
+	}
+	return nil
+}
+
+func (c *HTTPClient) do(ctx context.Context, endpoint, url, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, &Error{Kind: Upstream, URL: url, Err: err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", token)
+
+	res, err := c.send(endpoint, req)
+	if err != nil {
+		return nil, &Error{Kind: Upstream, URL: url, Err: err}
+	}
+	return res, nil
+}
+
+// Login fetches a new bearer token, sharing the login with any other callers
+// that are already waiting for one
+func (c *HTTPClient) Login(ctx context.Context) error {
+	_, _, err := c.tokens.refresh(ctx)
+	return err
+}
+
+// Token returns the current bearer token, logging in first if there isn't one,
+// eg: to keep a user's session once they've logged in
+func (c *HTTPClient) Token(ctx context.Context) (string, error) {
+	token, _, err := c.tokens.Token(ctx)
+	return token, err
+}
+
+func (c *HTTPClient) login(ctx context.Context) (string, error) {
+	url := c.appURL + "/s/auth/login"
+	if c.email == "" && c.password == "" && c.tokens.session {
+		return "", &Error{Kind: Unauthorized, URL: url, Err: ErrSessionExpired, Login: true}
+	}
+
+	reqBody := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{
+		c.email,
+		c.password,
+	}
+	reqJSON, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", &Error{Kind: Upstream, URL: url, Err: err, Login: true}
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(reqJSON))
+	if err != nil {
+		return "", &Error{Kind: Upstream, URL: url, Err: err, Login: true}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Content-Type", "application/json")
+
+	// make the request
+	res, err := c.send("login", req)
+	if err != nil {
+		return "", &Error{Kind: Upstream, URL: url, Err: err, Login: true}
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return "", &Error{Kind: Unauthorized, Status: res.StatusCode, URL: url, Login: true}
+	case res.StatusCode != http.StatusOK:
+		return "", &Error{Kind: Upstream, Status: res.StatusCode, URL: url, Login: true}
+	}
+
+	// handle response
+	var resBody struct {
+		Token string
+	}
+	if err := decode(res.Body, &resBody); err != nil {
+		return "", &Error{Kind: Upstream, Status: res.StatusCode, URL: url, Err: err, Login: true}
+	}
+
+	return resBody.Token, nil
+}
+
+// send makes the request and reports it to the OnRequest hook. Requests
+// include the id of the request that triggered them, if there is one.
+func (c *HTTPClient) send(endpoint string, req *http.Request) (*http.Response, error) {
+	if id := logging.RequestID(req.Context()); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+
+	start := time.Now()
+	res, err := c.http.Do(req)
+	if c.onRequest != nil {
+		status := 0
+		if err == nil {
+			status = res.StatusCode
+		}
+		c.onRequest(req.Context(), endpoint, status, time.Since(start))
+	}
+	return res, err
+}
+
+func decode(body io.Reader, out interface{}) error {
+	return json.NewDecoder(body).Decode(out)
+}
@@ -0,0 +1,228 @@
+package fig1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer is a stand-in for figure 1 that only accepts the token from the latest login
+type testServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	logins     int
+	valid      string
+	loginDelay time.Duration
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/s/auth/login", func(res http.ResponseWriter, req *http.Request) {
+		time.Sleep(ts.loginDelay)
+		ts.mu.Lock()
+		ts.logins++
+		ts.valid = fmt.Sprintf("Bearer token-%v", ts.logins)
+		token := ts.valid
+		ts.mu.Unlock()
+		json.NewEncoder(res).Encode(map[string]string{"token": token})
+	})
+	mux.HandleFunc("/s/case/", func(res http.ResponseWriter, req *http.Request) {
+		ts.mu.Lock()
+		valid := ts.valid
+		ts.mu.Unlock()
+		if valid == "" || req.Header.Get("Authorization") != valid {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Path != "/s/case/59076d6324d11b594b2dff1d" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res.Write([]byte(`{"_id": "59076d6324d11b594b2dff1d", "caption": "A case", "voteCount": 3}`))
+	})
+	mux.HandleFunc("/s/profile/public/", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(`{"username": "ccovic", "specialtyObject": {"strings": {"label": "Cardiology"}, "category": {"strings": {"label": "Physician"}}}}`))
+	})
+	mux.HandleFunc("/collections/", func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusBadGateway)
+	})
+	ts.Server = httptest.NewServer(mux)
+	return ts
+}
+
+// revoke rejects every token until the next login
+func (ts *testServer) revoke() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.valid = ""
+}
+
+func (ts *testServer) loginCount() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.logins
+}
+
+func TestClient(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	var requests []string
+	c := New(Config{AppURL: ts.URL, APIURL: ts.URL + "/", Email: "e", Password: "p", OnRequest: func(ctx context.Context, endpoint string, status int, elapsed time.Duration) {
+		requests = append(requests, fmt.Sprintf("%v %v", endpoint, status))
+	}})
+	ctx := context.Background()
+
+	// no token yet, so the first request should log in first
+	f1Case, err := c.Case(ctx, "59076d6324d11b594b2dff1d")
+	if err != nil {
+		t.Fatalf("Expected case to be retrieved, got %v", err)
+	}
+	if f1Case.Caption != "A case" || f1Case.VoteCount != 3 {
+		t.Errorf("Case was not decoded correctly: %+v", f1Case)
+	}
+	if logins := ts.loginCount(); logins != 1 {
+		t.Errorf("Expected 1 login, got %v", logins)
+	}
+
+	if _, err := c.Case(ctx, "000000000000000000000000"); !IsNotFound(err) {
+		t.Errorf("Expected NotFound error, got %v", err)
+	}
+
+	user, err := c.User(ctx, "ccovic")
+	if err != nil {
+		t.Fatalf("Expected user to be retrieved, got %v", err)
+	}
+	if user.Category != "Physician" || user.Specialty != "Cardiology" {
+		t.Errorf("Expected specialty to be copied from specialtyObject, got %v, %v", user.Category, user.Specialty)
+	}
+
+	_, err = c.Collection(ctx, "5907549889c89eef5b1b3511")
+	if !IsUpstream(err) {
+		t.Errorf("Expected Upstream error, got %v", err)
+	}
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusBadGateway {
+		t.Errorf("Expected error to include the upstream status, got %v", err)
+	}
+
+	expected := "login 200, case 200, case 404, user 200, collection 502"
+	if got := strings.Join(requests, ", "); got != expected {
+		t.Errorf("Expected OnRequest to see %v, got %v", expected, got)
+	}
+}
+
+func TestFakeClient(t *testing.T) {
+	var c Client = NewFake()
+	fake := c.(*Fake)
+	fake.Cases["abc"] = &Case{ID: "abc", Caption: "fake"}
+
+	f1Case, err := c.Case(context.Background(), "abc")
+	if err != nil || f1Case.Caption != "fake" {
+		t.Errorf("Expected fake case, got %v (err: %v)", f1Case, err)
+	}
+	f1Case.Caption = "changed"
+	if fake.Cases["abc"].Caption != "fake" {
+		t.Errorf("Expected fake to return copies")
+	}
+
+	if _, err := c.User(context.Background(), "nobody"); !IsNotFound(err) {
+		t.Errorf("Expected NotFound error, got %v", err)
+	}
+	if fake.Calls["case"] != 1 || fake.Calls["user"] != 1 {
+		t.Errorf("Expected calls to be counted, got %v", fake.Calls)
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	var observed []error
+	c := New(Config{AppURL: server.URL, OnLogin: func(err error) {
+		observed = append(observed, err)
+	}})
+
+	err := c.Login(context.Background())
+	if !IsLoginError(err) || !IsUpstream(err) {
+		t.Errorf("Expected upstream login error, got %v", err)
+	}
+
+	status = http.StatusUnauthorized
+	err = c.Login(context.Background())
+	if !IsLoginError(err) || !IsUnauthorized(err) {
+		t.Errorf("Expected unauthorized login error, got %v", err)
+	}
+
+	if _, err := c.Case(context.Background(), "59076d6324d11b594b2dff1d"); !IsLoginError(err) {
+		t.Errorf("Expected requests to return the login error, got %v", err)
+	}
+	if len(observed) != 3 {
+		t.Errorf("Expected OnLogin to be called for every attempt, got %v", len(observed))
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	// someone logs in, eg: with their own email and password
+	if err := New(Config{AppURL: server.URL, Email: "e", Password: "p"}).Login(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(Config{AppURL: server.URL, Token: "Bearer token-1"})
+	if _, err := c.Case(context.Background(), "59076d6324d11b594b2dff1d"); err != nil {
+		t.Fatalf("Expected the session token to be used, got %v", err)
+	}
+	if token, _ := c.Token(context.Background()); token != "Bearer token-1" {
+		t.Errorf("Expected the session token, got %q", token)
+	}
+
+	server.revoke()
+	_, err := c.Case(context.Background(), "59076d6324d11b594b2dff1d")
+	if !IsSessionExpired(err) || !IsUnauthorized(err) {
+		t.Errorf("Expected the session to expire, got %v", err)
+	}
+	if server.loginCount() != 1 {
+		t.Errorf("Expected a session not to log in with empty credentials, got %v logins", server.loginCount())
+	}
+}
+
+func TestPathEscaping(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		paths = append(paths, req.URL.EscapedPath()+"?"+req.URL.RawQuery)
+		mu.Unlock()
+		res.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	c := New(Config{AppURL: server.URL, APIURL: server.URL, Token: "Bearer token-1"})
+	ctx := context.Background()
+	for _, id := range []string{"../collections/1?admin=1", "a/b", ".", "..", ""} {
+		if _, err := c.Case(ctx, id); !IsNotFound(err) {
+			t.Errorf("Expected case %q not to be found, got %v", id, err)
+		}
+	}
+	c.User(ctx, "../../s/auth/login")
+	c.Collection(ctx, "1#x")
+
+	expected := "/s/case/..%2Fcollections%2F1%3Fadmin=1?, /s/case/a%2Fb?, /s/profile/public/..%2F..%2Fs%2Fauth%2Flogin?, /collections/1%23x?"
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(paths, ", "); got != expected {
+		t.Errorf("Expected every id to stay in its own path segment, got %v", got)
+	}
+}
@@ -0,0 +1,98 @@
+package fig1
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSessionExpired is the Err of the login error from a client that was only
+// given a Token, once that token has been rejected or has expired
+var ErrSessionExpired = errors.New("session expired, log in again")
+
+// ErrorKind groups errors by what the caller should do about them
+type ErrorKind int
+
+const (
+	// Upstream is anything wrong on figure 1's side, including connection failures
+	Upstream ErrorKind = iota
+	// NotFound means the case, user or collection doesn't exist
+	NotFound
+	// Unauthorized means the credentials were rejected
+	Unauthorized
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case NotFound:
+		return "not found"
+	case Unauthorized:
+		return "unauthorized"
+	}
+	return "upstream error"
+}
+
+// Error is returned for every failed figure 1 request
+type Error struct {
+	Kind ErrorKind
+	// Status is the http status returned by figure 1, or 0 if there was no response
+	Status int
+	URL    string
+	Err    error
+	// Login is true if this happened while logging in, rather than fetching content
+	Login bool
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("figure 1 %v", e.Kind)
+	if e.Login {
+		msg = fmt.Sprintf("figure 1 login %v", e.Kind)
+	}
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" (status: %v)", e.Status)
+	}
+	if e.URL != "" {
+		msg += " " + e.URL
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func errorKind(err error) (ErrorKind, bool) {
+	e, ok := err.(*Error)
+	if !ok {
+		return 0, false
+	}
+	return e.Kind, true
+}
+
+// IsNotFound reports whether err is a figure 1 NotFound error
+func IsNotFound(err error) bool {
+	kind, ok := errorKind(err)
+	return ok && kind == NotFound
+}
+
+// IsUnauthorized reports whether err is a figure 1 Unauthorized error
+func IsUnauthorized(err error) bool {
+	kind, ok := errorKind(err)
+	return ok && kind == Unauthorized
+}
+
+// IsLoginError reports whether err came from failing to log in to figure 1
+func IsLoginError(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Login
+}
+
+// IsSessionExpired reports whether err means a user's session needs to be replaced
+func IsSessionExpired(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Err == ErrSessionExpired
+}
+
+// IsUpstream reports whether err is a figure 1 Upstream error
+func IsUpstream(err error) bool {
+	kind, ok := errorKind(err)
+	return ok && kind == Upstream
+}
@@ -0,0 +1,91 @@
+package fig1
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake is an in-memory Client for tests. Content is looked up by id/username,
+// anything missing is a NotFound error.
+type Fake struct {
+	mu          sync.Mutex
+	Cases       map[string]*Case
+	Users       map[string]*User
+	Collections map[string]*Collection
+
+	// Err, if set, is returned from every call
+	Err error
+
+	// Calls counts requests by content type ("case", "user", "collection" and "login")
+	Calls map[string]int
+}
+
+// NewFake creates an empty fake
+func NewFake() *Fake {
+	return &Fake{
+		Cases:       map[string]*Case{},
+		Users:       map[string]*User{},
+		Collections: map[string]*Collection{},
+		Calls:       map[string]int{},
+	}
+}
+
+func (f *Fake) call(kind string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Calls == nil {
+		f.Calls = map[string]int{}
+	}
+	f.Calls[kind]++
+	return f.Err
+}
+
+// Case returns a copy of the stored case
+func (f *Fake) Case(ctx context.Context, id string) (*Case, error) {
+	if err := f.call("case"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.Cases[id]
+	if !ok {
+		return nil, &Error{Kind: NotFound, Status: 404}
+	}
+	copied := *c
+	return &copied, nil
+}
+
+// User returns a copy of the stored user
+func (f *Fake) User(ctx context.Context, username string) (*User, error) {
+	if err := f.call("user"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.Users[username]
+	if !ok {
+		return nil, &Error{Kind: NotFound, Status: 404}
+	}
+	copied := *u
+	return &copied, nil
+}
+
+// Collection returns a copy of the stored collection
+func (f *Fake) Collection(ctx context.Context, id string) (*Collection, error) {
+	if err := f.call("collection"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.Collections[id]
+	if !ok {
+		return nil, &Error{Kind: NotFound, Status: 404}
+	}
+	copied := *c
+	return &copied, nil
+}
+
+// Login always succeeds unless Err is set
+func (f *Fake) Login(ctx context.Context) error {
+	return f.call("login")
+}
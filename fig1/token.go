@@ -0,0 +1,148 @@
+package fig1
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRefreshBefore is how long before a JWT expires that it gets replaced
+	DefaultRefreshBefore = time.Minute
+	// a login is shared by every waiting request, so it can't use any one caller's context
+	loginTimeout = 30 * time.Second
+)
+
+// tokenManager hands out the current bearer token. Refreshes are serialized so
+// concurrent requests that all get a 401 wait on a single login.
+type tokenManager struct {
+	login         func(ctx context.Context) (string, error)
+	refreshBefore time.Duration
+	now           func() time.Time
+	onLogin       func(err error)
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time // zero if the token isn't a JWT
+	// version is bumped on every successful refresh, so callers can tell if the
+	// token they were rejected with has already been replaced
+	version int
+	// inflight is closed when the current refresh finishes
+	inflight chan struct{}
+	err      error
+	// session is true if the first token was given rather than logged in for
+	session bool
+}
+
+func newTokenManager(login func(ctx context.Context) (string, error), refreshBefore time.Duration) *tokenManager {
+	if refreshBefore == 0 {
+		refreshBefore = DefaultRefreshBefore
+	}
+	return &tokenManager{
+		login:         login,
+		refreshBefore: refreshBefore,
+		now:           time.Now,
+	}
+}
+
+// Token returns a usable token, logging in first if there isn't one or it's about to expire
+func (m *tokenManager) Token(ctx context.Context) (string, int, error) {
+	m.mu.Lock()
+	valid := m.token != "" && (m.expires.IsZero() || m.now().Add(m.refreshBefore).Before(m.expires))
+	token, version := m.token, m.version
+	m.mu.Unlock()
+
+	if valid {
+		return token, version, nil
+	}
+	return m.refresh(ctx)
+}
+
+// Invalidate is called when `version` of the token was rejected. Only the first
+// caller with that version triggers a login, everyone else gets the new token.
+func (m *tokenManager) Invalidate(ctx context.Context, version int) (string, int, error) {
+	m.mu.Lock()
+	if m.version != version && m.token != "" {
+		token, current := m.token, m.version
+		m.mu.Unlock()
+		return token, current, nil
+	}
+	m.mu.Unlock()
+	return m.refresh(ctx)
+}
+
+// set uses an existing token, eg: a user's session
+func (m *tokenManager) set(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token = token
+	m.expires = jwtExpiry(token)
+	m.version++
+	m.session = true
+}
+
+// refresh logs in, or waits for the login that's already happening
+func (m *tokenManager) refresh(ctx context.Context) (string, int, error) {
+	m.mu.Lock()
+	if m.inflight == nil {
+		m.inflight = make(chan struct{})
+		go m.doLogin(m.inflight)
+	}
+	done := m.inflight
+	m.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return "", 0, ctx.Err()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token, m.version, m.err
+}
+
+func (m *tokenManager) doLogin(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+	token, err := m.login(ctx)
+	if m.onLogin != nil {
+		m.onLogin(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+	if err == nil {
+		m.token = token
+		m.expires = jwtExpiry(token)
+		m.version++
+	}
+	m.inflight = nil
+	close(done)
+}
+
+// jwtExpiry reads the `exp` claim without verifying the token, figure 1 does that.
+// Anything that isn't a JWT never expires as far as we know.
+func jwtExpiry(token string) time.Time {
+	token = strings.TrimPrefix(token, "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
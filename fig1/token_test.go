@@ -0,0 +1,100 @@
+package fig1
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentRefresh(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	ts.loginDelay = 50 * time.Millisecond
+
+	c := New(Config{AppURL: ts.URL})
+	ctx := context.Background()
+	if err := c.Login(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// every request gets a 401 at the same time, but they should share one login
+	ts.revoke()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Case(ctx, "59076d6324d11b594b2dff1d"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Expected request to succeed after refresh, got %v", err)
+	}
+	if logins := ts.loginCount(); logins != 2 {
+		t.Errorf("Expected a single refresh (2 logins total), got %v logins", logins)
+	}
+}
+
+func TestRefreshRetriesOnce(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	// tokens are always rejected
+	c := New(Config{AppURL: ts.URL})
+	c.tokens.login = func(ctx context.Context) (string, error) {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		ts.logins++
+		return "Bearer rejected", nil
+	}
+
+	_, err := c.Case(context.Background(), "59076d6324d11b594b2dff1d")
+	if !IsUnauthorized(err) {
+		t.Errorf("Expected Unauthorized error, got %v", err)
+	}
+	// one to get the first token, one retry after the 401
+	if logins := ts.loginCount(); logins != 2 {
+		t.Errorf("Expected 2 logins, got %v", logins)
+	}
+}
+
+func testJWT(exp time.Time) string {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp": %v}`, exp.Unix())))
+	return "Bearer header." + claims + ".signature"
+}
+
+func TestJWTRefreshAhead(t *testing.T) {
+	logins := 0
+	expiry := time.Now().Add(30 * time.Second)
+	m := newTokenManager(func(ctx context.Context) (string, error) {
+		logins++
+		return testJWT(expiry), nil
+	}, time.Minute)
+
+	ctx := context.Background()
+	m.Token(ctx)
+	m.Token(ctx)
+	if logins != 2 {
+		t.Errorf("Expected token expiring within a minute to be refreshed, got %v logins", logins)
+	}
+
+	expiry = time.Now().Add(time.Hour)
+	m.Token(ctx)
+	m.Token(ctx)
+	if logins != 3 {
+		t.Errorf("Expected long lived token to be reused, got %v logins", logins)
+	}
+
+	if !jwtExpiry("not-a-jwt").IsZero() {
+		t.Errorf("Expected opaque tokens to have no expiry")
+	}
+}
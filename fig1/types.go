@@ -0,0 +1,104 @@
+package fig1
+
+import (
+	"time"
+)
+
+// Case is a single figure 1 case (image or text post)
+type Case struct {
+	ID           string `json:"_id"`
+	Caption      string `json:"caption"`
+	IsPagingCase bool   `json:"isPagingCase"`
+
+	// stats
+	ImageViews   string `json:"imageViews"`
+	Followers    int    `json:"followers"`
+	CommentCount int    `json:"CommentCount"`
+	VoteCount    int    `json:"voteCount"`
+
+	// author
+	Author struct {
+		Username       string `json:"username"`
+		TopContributor bool   `json:"topContributor"`
+		Verified       bool   `json:"verified"`
+	}
+
+	// CachedAt is set when this is an old copy served from a cache, because figure 1 couldn't be reached
+	CachedAt time.Time `json:"-"`
+}
+
+// User is a public figure 1 profile
+type User struct {
+	ID             string `json:"_id"`
+	Username       string `json:"username"`
+	Verified       bool   `json:"verified"`
+	TopContributor bool   `json:"topContributor"`
+	Category       string
+	Specialty      string
+
+	// extra info
+	Country        string `json:"country"`
+	ProfileCountry string `json:"profileCountry"`
+	FullName       string `json:"fullName"`
+	Institution    string `json:"institution"`
+	Bio            string `json:"bio"`
+	Link           string `json:"link"`
+
+	// specialty object
+	SpecialtyObject struct {
+		Category struct {
+			Strings struct {
+				Label string `json:"label"`
+			} `json:"strings"`
+		} `json:"category"`
+		Strings struct {
+			Label string `json:"label"`
+		} `json:"strings"`
+	} `json:"specialtyObject"`
+
+	// stats
+	CommentsCount  int `json:"profileCommentsCount"`
+	FavoritesCount int `json:"profileFavoritesCount"`
+	FollowersCount int `json:"profileFollowersCount"`
+	FollowingCount int `json:"profileFollowingCount"`
+	UploadsCount   int `json:"profileUploadsCount"`
+
+	// CachedAt is set when this is an old copy served from a cache, because figure 1 couldn't be reached
+	CachedAt time.Time `json:"-"`
+}
+
+// Collection is a curated list of cases
+type Collection struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ID          string `json:"id"`
+	Size        int    `json:"size"`
+	Embedded    struct {
+		Items []struct {
+			ID           string `json:"_id"`
+			Caption      string `json:"caption"`
+			Title        string `json:"title"`
+			ContentType  int    `json:"contentType"`
+			CommentCount int    `json:"commentCount"`
+			Followers    int    `json:"followers"`
+			VoteCount    int    `json:"voteCount"`
+			Links        struct {
+				Image struct {
+					Href string `json:"href"`
+				} `json:"image"`
+			} `json:"_links"`
+		} `json:"items"`
+
+		Authors []struct {
+			Username          string `json:"username"`
+			ID                string `json:"_id"`
+			Verified          bool   `json:"verified"`
+			SpecialtyName     string `json:"specialtyName"`
+			SpecialtyCategory string `json:"specialtyCategory"`
+			TopContributor    bool   `json:"topContributor"`
+		} `json:"authors"`
+	} `json:"_embedded"`
+
+	// CachedAt is set when this is an old copy served from a cache, because figure 1 couldn't be reached
+	CachedAt time.Time `json:"-"`
+}
@@ -1,16 +1,35 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+
+	"app/fig1"
 )
 
+const busyMessage = "The Figure 1 app is busy right now, please try again in a minute"
+
+// loadingMessage is only shown to the requester, and is replaced by the content or an error
+const loadingMessage = "Fetching content..."
+
 type slashCommandRequestBody struct {
-	Token       string
+	TeamID      string
+	TeamDomain  string
 	ChannelID   string
+	UserID      string
 	Username    string
 	Text        string
 	ResponseURL string
+
+	// show the result only to the requester first
+	Preview bool
+}
+
+func (body *slashCommandRequestBody) target() responseTarget {
+	return responseTarget{URL: body.ResponseURL, Team: body.TeamID, Channel: body.ChannelID, User: body.UserID}
 }
 
 func (app *SlackApp) slashCommandHandler(res http.ResponseWriter, req *http.Request) {
@@ -19,130 +38,218 @@ func (app *SlackApp) slashCommandHandler(res http.ResponseWriter, req *http.Requ
 		return
 	}
 
-	var handler func(*slashCommandRequestBody)
-
-	// check if handler exists for path
+	// check if handler exists for path, `/case`, `/user` and `/collection` are
+	// aliases for the matching `/fig1` subcommand
 	switch req.URL.Path {
-	case "/case":
-		handler = app.handleCase
-	case "/user":
-		handler = app.handleUser
-	case "/collection":
-		handler = app.handleCollection
+	case fig1Command, "/case", "/user", "/collection":
 	default:
 		http.Error(res, "Not found", http.StatusNotFound)
 		return
 	}
 
-	logErr("Request made to '%v'", req.URL.Path)
+	log := loggerFrom(req.Context())
+	log.Info("Slash command received")
 
 	// parse form
 	if err := req.ParseForm(); err != nil {
 		msg := "Failed to parse body"
-		(&requestError{msg, msg, err}).handleError(res)
+		(&requestError{msg, msg, err}).handleError(req.Context(), res)
 		return
 	}
 
 	// body
 	body := slashCommandRequestBody{
-		Token:       req.FormValue("token"),
+		TeamID:      req.FormValue("team_id"),
+		TeamDomain:  req.FormValue("team_domain"),
 		ChannelID:   req.FormValue("channel_id"),
+		UserID:      req.FormValue("user_id"),
 		Username:    req.FormValue("user_name"),
 		Text:        req.FormValue("text"),
 		ResponseURL: req.FormValue("response_url"),
 	}
+	body.Text, body.Preview = parsePreviewFlags(body.Text, app.previewEnabled(body.ChannelID))
 
-	// check request token is valid
-	if body.Token != app.VerificationToken {
-		msg := fmt.Sprintf("Token provided did not match (token: %v)", body.Token)
-		(&requestError{"Token provided did not match", msg, nil}).handleError(res)
-		return
+	var cmd *subcommand
+	if req.URL.Path == fig1Command {
+		cmd, body.Text = app.parseSubcommand(body.Text)
+	} else {
+		cmd = app.findSubcommand(strings.TrimPrefix(req.URL.Path, "/"))
 	}
+	commandsTotal.Inc(cmd.name)
 
 	// more basic body validation
-	if body.ChannelID == "" || body.Username == "" || body.Text == "" {
-		msg := fmt.Sprintf("Invalid request body (channel: %v, username: %v, text: %v)", body.Token, body.Username, body.Text)
-		(&requestError{"Invalid body", msg, nil}).handleError(res)
+	if body.ChannelID == "" || body.Username == "" {
+		msg := fmt.Sprintf("Invalid request body (channel: %v, username: %v, text: %v)", body.ChannelID, body.Username, body.Text)
+		(&requestError{"Invalid body", msg, nil}).handleError(req.Context(), res)
+		return
+	}
+
+	// some commands don't need to fetch anything (or are missing what to fetch), so respond immediately
+	var reply *SlackResponse
+	switch {
+	case cmd.reply != nil:
+		reply = cmd.reply(&body)
+	case body.Text == "":
+		reply = cmd.replyUsage(req.URL.Path)
+	}
+	if reply != nil {
+		res.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(res).Encode(reply); err != nil {
+			log.Error("Failed to encode reply", "command", cmd.name, "err", err)
+		}
 		return
 	}
 
-	// assume everything is fine, any further errors will be sent via the `response_url`
-	res.Write([]byte("Fetching content..."))
+	// a retry of a command that's already queued, the first delivery's response is on its way
+	key := deliveryKey(req.FormValue("trigger_id"), body.ResponseURL)
+	if app.duplicate(req.Context(), "command", key, req.Header) {
+		res.WriteHeader(http.StatusOK)
+		return
+	}
 
-	// spawn slack response
-	go func() {
-		handler(&body)
-	}()
+	// queue slack response
+	queued := app.queue.enqueue(newJob(req.Context(), req.URL.Path, func(ctx context.Context) {
+		cmd.handler(ctx, &body)
+	}))
+	if !queued {
+		app.forgetDelivery("command", key)
+		res.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(res).Encode(&SlackResponse{
+			ResponseType: "ephemeral",
+			Text:         busyMessage,
+		})
+		return
+	}
+
+	// assume everything is fine, the content or any further errors will replace this via the `response_url`
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(&SlackResponse{
+		ResponseType: "ephemeral",
+		Text:         loadingMessage,
+	})
 }
 
-func (app *SlackApp) handleCase(body *slashCommandRequestBody) {
+func (app *SlackApp) handleCase(ctx context.Context, body *slashCommandRequestBody) {
 	// validate case id
 	var id string
 	if id = getCaseID(body.Text); id == "" {
 		msg := fmt.Sprintf("Failed to parse case url/id (text: %v)", body.Text)
-		(&slackError{"Invalid case id/url, please try again", msg, nil}).handleError(body.ResponseURL)
+		(&slackError{"Invalid case id/url, please try again", msg, nil}).handleCommandError(ctx, body.ResponseURL)
 		return
 	}
 
 	// get case
-	f1Case, err := app.getCase(id)
+	f1Case, err := app.contentClient(body).Case(ctx, id)
 	if err != nil {
 		msg := fmt.Sprintf("Failed retrieve case (id: %v)", id)
-		(&slackError{"Failed to retrieve case", msg, err}).handleError(body.ResponseURL)
+		(&slackError{fig1ErrorResponse(contentCase, err), msg, err}).handleCommandError(ctx, body.ResponseURL)
 		return
 	}
 
 	// generate content
-	attachments := generateCaseContent(&f1Case, body.Username)
+	content := app.renderer(body.TeamID).renderCase(f1Case, body.Username)
 
 	// respond
-	respondToSlashCommand(body.ResponseURL, attachments)
+	app.respondWithContent(ctx, body, previewRef{contentCase, id, body.Username, body.UserID}, content)
 }
 
-func (app *SlackApp) handleUser(body *slashCommandRequestBody) {
+func (app *SlackApp) handleUser(ctx context.Context, body *slashCommandRequestBody) {
 	// get username
 	var username string
 	if username = getUsername(body.Text); username == "" {
 		msg := fmt.Sprintf("Failed to parse username (text: %v)", body.Text)
-		(&slackError{"Invalid user id/url, please try again", msg, nil}).handleError(body.ResponseURL)
+		(&slackError{"Invalid user id/url, please try again", msg, nil}).handleCommandError(ctx, body.ResponseURL)
 		return
 	}
 
 	// get user data
-	f1User, err := app.getUser(username)
+	f1User, err := app.contentClient(body).User(ctx, username)
 	if err != nil {
 		msg := fmt.Sprintf("Failed retrieve user data (username: %v)", username)
-		(&slackError{"Failed to retrieve user", msg, err}).handleError(body.ResponseURL)
+		(&slackError{fig1ErrorResponse(contentUser, err), msg, err}).handleCommandError(ctx, body.ResponseURL)
 		return
 	}
 
 	// generate content
-	attachments := generateUserContent(&f1User, body.Username)
+	content := app.renderer(body.TeamID).renderUser(f1User, body.Username)
 
 	// respond
-	respondToSlashCommand(body.ResponseURL, attachments)
+	app.respondWithContent(ctx, body, previewRef{contentUser, username, body.Username, body.UserID}, content)
 }
 
-func (app *SlackApp) handleCollection(body *slashCommandRequestBody) {
+func (app *SlackApp) handleCollection(ctx context.Context, body *slashCommandRequestBody) {
 	// get id
 	var id string
 	if id = getCollectionID(body.Text); id == "" {
 		msg := fmt.Sprintf("Failed to parse collection url/id (text: %v)", body.Text)
-		(&slackError{"Invalid collection id/url, please try again", msg, nil}).handleError(body.ResponseURL)
+		(&slackError{"Invalid collection id/url, please try again", msg, nil}).handleCommandError(ctx, body.ResponseURL)
 		return
 	}
 
 	// get user data
-	f1Collection, err := app.getCollection(id)
+	f1Collection, err := app.contentClient(body).Collection(ctx, id)
 	if err != nil {
 		msg := fmt.Sprintf("Failed retrieve collection (id: %v)", id)
-		(&slackError{"Failed to retrieve collection", msg, err}).handleError(body.ResponseURL)
+		(&slackError{fig1ErrorResponse(contentCollection, err), msg, err}).handleCommandError(ctx, body.ResponseURL)
 		return
 	}
 
 	// generate content
-	attachments := generateCollectionContent(&f1Collection, body.Username)
+	content := app.renderer(body.TeamID).renderCollection(f1Collection, body.Username)
 
 	// respond
-	respondToSlashCommand(body.ResponseURL, attachments)
+	app.respondWithContent(ctx, body, previewRef{contentCollection, id, body.Username, body.UserID}, content)
+}
+
+// contentClient is the figure 1 client for a slash command, using the requester's own
+// session if they've logged in with `/fig1 login`. What their account can see might not be
+// public, so it's only shown to them until they post it.
+func (app *SlackApp) contentClient(body *slashCommandRequestBody) fig1.Client {
+	if client := app.userFigure1(body.TeamID, body.UserID); client != nil {
+		body.Preview = true
+		return client
+	}
+	return app.workspaceFigure1(body.TeamID)
+}
+
+// respondWithContent posts a preview to the channel, or only to the requester
+// with post/cancel buttons if preview mode is on. Either way the loading message goes away.
+func (app *SlackApp) respondWithContent(ctx context.Context, body *slashCommandRequestBody, ref previewRef, content *SlackResponse) {
+	if !body.Preview {
+		content.Blocks = append(content.Blocks, previewActions(ref))
+		app.respondToSlashCommand(ctx, body, content)
+		return
+	}
+
+	// keep the message as it will be posted, so posting it doesn't fetch the content again
+	posted := *content
+	posted.Blocks = append(append([]Block{}, content.Blocks...), previewActions(ref))
+	posted.ResponseType = "in_channel"
+	key, err := app.previews.add(ref, &posted)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to keep preview (id: %v)", ref.ID)
+		(&slackError{"", msg, err}).handleCommandError(ctx, body.ResponseURL)
+		return
+	}
+
+	content.Blocks = append(content.Blocks, confirmActions(key))
+	content.ResponseType = "ephemeral"
+	content.ReplaceOriginal = true
+	if err := app.respond(ctx, body.target(), content); err != nil {
+		loggerFrom(ctx).Error("Failed to post preview", "id", ref.ID, "err", err)
+	}
+}
+
+// fig1ErrorResponse is the message shown in slack when a figure 1 request fails
+func fig1ErrorResponse(kind string, err error) string {
+	if fig1.IsSessionExpired(err) {
+		return fmt.Sprintf("Your Figure 1 session has expired, please log in again with `%v login`", fig1Command)
+	}
+	if fig1.IsLoginError(err) {
+		return "Figure 1 auth is currently unavailable, please try again later"
+	}
+	if fig1.IsNotFound(err) {
+		return fmt.Sprintf("Couldn't find that %v on Figure 1", kind)
+	}
+	return "Failed to retrieve " + kind
 }
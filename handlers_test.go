@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"app/fig1"
+)
+
+// responseURLRecorder is a stand-in for a response_url that keeps everything posted to it
+type responseURLRecorder struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []map[string]interface{}
+}
+
+func newResponseURLRecorder() *responseURLRecorder {
+	r := &responseURLRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&body)
+		r.mu.Lock()
+		r.bodies = append(r.bodies, body)
+		r.mu.Unlock()
+	}))
+	return r
+}
+
+// summary is "response_type replace/delete" for every post, eg: "in_channel", "ephemeral replace"
+func (r *responseURLRecorder) summary() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var posts []string
+	for _, body := range r.bodies {
+		var post []string
+		if kind, ok := body["response_type"].(string); ok {
+			post = append(post, kind)
+		}
+		if body["replace_original"] == true {
+			post = append(post, "replace")
+		}
+		if body["delete_original"] == true {
+			post = append(post, "delete")
+		}
+		posts = append(posts, strings.Join(post, " "))
+	}
+	return posts
+}
+
+func TestSlashCommandLoadingMessage(t *testing.T) {
+	fake := fig1.NewFake()
+	fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "Chest x-ray"}
+	app := &SlackApp{f1: fake, queue: newJobQueue(1, 1)}
+
+	loading := newResponseURLRecorder()
+	defer loading.Close()
+	form := url.Values{
+		"channel_id":   {"C1"},
+		"user_name":    {"bob"},
+		"text":         {"case 59076d6324d11b594b2dff1d"},
+		"response_url": {loading.URL},
+	}
+	req := httptest.NewRequest("POST", fig1Command, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	app.slashCommandHandler(res, req)
+
+	var reply SlackResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &reply); err != nil || reply.ResponseType != "ephemeral" || reply.Text != loadingMessage {
+		t.Errorf("Expected an ephemeral loading message, got %v (err: %v)", res.Body.String(), err)
+	}
+	app.queue.shutdown(context.Background())
+	if posts := loading.summary(); strings.Join(posts, ",") != "in_channel,delete" {
+		t.Errorf("Expected the case to be posted and the loading message deleted, got %v", posts)
+	}
+
+	// a content command without anything to look up gets its usage
+	usages := []struct {
+		path     string
+		text     string
+		expected string
+	}{
+		{fig1Command, "case", "Usage: `/fig1 case [case url or case id]`"},
+		{"/case", "", "Usage: `/case [case url or case id]`"},
+	}
+	for _, test := range usages {
+		form.Set("text", test.text)
+		req := httptest.NewRequest("POST", test.path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		app.slashCommandHandler(res, req)
+		if err := json.Unmarshal(res.Body.Bytes(), &reply); err != nil || res.Code != 200 || !strings.HasPrefix(reply.Text, test.expected) {
+			t.Errorf("Expected %v %q to reply with its usage, got %v %v", test.path, test.text, res.Code, res.Body.String())
+		}
+	}
+
+	recorder := newResponseURLRecorder()
+	defer recorder.Close()
+
+	tests := []struct {
+		name     string
+		body     slashCommandRequestBody
+		expected []string
+	}{
+		{"posted", slashCommandRequestBody{Text: "59076d6324d11b594b2dff1d"}, []string{"in_channel", "delete"}},
+		{"preview", slashCommandRequestBody{Text: "59076d6324d11b594b2dff1d", Preview: true}, []string{"ephemeral replace"}},
+		{"not found", slashCommandRequestBody{Text: "5907549889c89eef5b1b3511"}, []string{"ephemeral replace"}},
+		{"invalid", slashCommandRequestBody{Text: "nope"}, []string{"ephemeral replace"}},
+	}
+	for _, test := range tests {
+		recorder.mu.Lock()
+		recorder.bodies = nil
+		recorder.mu.Unlock()
+
+		test.body.ChannelID, test.body.Username, test.body.ResponseURL = "C1", "bob", recorder.URL
+		app.handleCase(context.Background(), &test.body)
+
+		posts := recorder.summary()
+		if strings.Join(posts, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Expected %v to post %v, got %v", test.name, test.expected, posts)
+		}
+	}
+}
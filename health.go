@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// healthzHandler only says the process is up, for restarting it when it's stuck
+func (app *SlackApp) healthzHandler(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler says whether requests should be sent here. The config has
+// always loaded by the time it's serving, so it checks that figure 1 is logged
+// in, the job queue has room and the app isn't shutting down.
+func (app *SlackApp) readyzHandler(res http.ResponseWriter, req *http.Request) {
+	var problems []string
+	if atomic.LoadInt32(&app.stopping) == 1 {
+		problems = append(problems, "shutting down")
+	}
+	if !app.auth.status().Healthy {
+		problems = append(problems, "not logged in to figure 1")
+	}
+	if app.queue.depth() >= app.queue.capacity() {
+		problems = append(problems, "job queue is full")
+	}
+
+	status := struct {
+		Ready    bool     `json:"ready"`
+		Problems []string `json:"problems,omitempty"`
+	}{
+		Ready:    len(problems) == 0,
+		Problems: problems,
+	}
+
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(res, code, status)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadyzHandler(t *testing.T) {
+	newApp := func() *SlackApp {
+		app := &SlackApp{
+			auth: newAuthMonitor(func(ctx context.Context) error { return nil }),
+			// no workers, so queued jobs stay queued
+			queue: &jobQueue{jobs: make(chan *job, 1)},
+		}
+		app.auth.observe(nil)
+		return app
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(app *SlackApp)
+		status int
+	}{
+		{"ready", func(app *SlackApp) {}, 200},
+		{"figure 1 down", func(app *SlackApp) {
+			app.auth.mu.Lock()
+			app.auth.healthy = false
+			app.auth.lastErr = errors.New("bad password")
+			app.auth.mu.Unlock()
+		}, 503},
+		{"queue full", func(app *SlackApp) {
+			app.queue.enqueue(newJob(context.Background(), "test", func(ctx context.Context) {}))
+		}, 503},
+		{"shutting down", func(app *SlackApp) { atomic.StoreInt32(&app.stopping, 1) }, 503},
+	}
+
+	for _, test := range tests {
+		app := newApp()
+		test.setup(app)
+
+		res := httptest.NewRecorder()
+		app.readyzHandler(res, httptest.NewRequest("GET", "/readyz", nil))
+		if res.Code != test.status {
+			t.Errorf("Expected %v when %v, got %v: %v", test.status, test.name, res.Code, res.Body.String())
+		}
+
+		res = httptest.NewRecorder()
+		app.healthzHandler(res, httptest.NewRequest("GET", "/healthz", nil))
+		if res.Code != 200 {
+			t.Errorf("Expected healthz to be 200 when %v, got %v", test.name, res.Code)
+		}
+	}
+}
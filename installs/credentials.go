@@ -0,0 +1,92 @@
+package installs
+
+import (
+	"fmt"
+	"time"
+
+	"app/jsonfile"
+)
+
+// CredentialsFile is the name of the file CredentialStore keeps in its data dir
+const CredentialsFile = "figure1_credentials.json"
+
+// Credentials is the figure 1 account a team's lookups are made with
+type Credentials struct {
+	TeamID    string    `json:"team_id"`
+	Email     string    `json:"email"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Password is never written out as is, see storedCredentials
+	Password string `json:"-"`
+}
+
+// storedCredentials are Credentials as they're saved to the file
+type storedCredentials struct {
+	Credentials
+	SealedPassword string `json:"password"`
+}
+
+// CredentialStore is the workspaces with their own figure 1 account, by team id
+type CredentialStore struct {
+	cipher *Cipher
+	table  *jsonfile.Table
+}
+
+// NewCredentialStore creates an empty store, changes to it aren't written anywhere
+func NewCredentialStore(cipher *Cipher) *CredentialStore {
+	s := &CredentialStore{cipher: cipher}
+	s.table = jsonfile.NewTable(func(teamID string, value interface{}) (interface{}, error) {
+		creds := value.(Credentials)
+		sealed, err := s.cipher.Seal(teamID, creds.Password)
+		return storedCredentials{Credentials: creds, SealedPassword: sealed}, err
+	})
+	return s
+}
+
+// OpenCredentials reads the credentials saved in dir, decrypting their passwords
+func OpenCredentials(dir string, cipher *Cipher) (*CredentialStore, error) {
+	s := NewCredentialStore(cipher)
+	var stored []storedCredentials
+	if err := s.table.Open(dir, CredentialsFile, &stored); err != nil {
+		return nil, err
+	}
+	for _, creds := range stored {
+		password, err := cipher.Open(creds.TeamID, creds.SealedPassword)
+		if err != nil {
+			return nil, fmt.Errorf("team %v: %v", creds.TeamID, err)
+		}
+		creds.Password = password
+		s.table.Load(creds.TeamID, creds.Credentials)
+	}
+	return s, nil
+}
+
+// Get returns the credentials for a team
+func (s *CredentialStore) Get(teamID string) (Credentials, bool) {
+	creds, ok := s.table.Get(teamID)
+	if !ok {
+		return Credentials{}, false
+	}
+	return creds.(Credentials), true
+}
+
+// List returns every team's credentials, sorted by team id
+func (s *CredentialStore) List() []Credentials {
+	values := s.table.Values()
+	list := make([]Credentials, 0, len(values))
+	for _, creds := range values {
+		list = append(list, creds.(Credentials))
+	}
+	return list
+}
+
+// Save adds or replaces the credentials for creds.TeamID
+func (s *CredentialStore) Save(creds Credentials) error {
+	return s.table.Set(creds.TeamID, creds)
+}
+
+// Delete removes a team's credentials, so it goes back to the default account.
+// It returns false if the team didn't have any.
+func (s *CredentialStore) Delete(teamID string) (bool, error) {
+	return s.table.Delete(teamID)
+}
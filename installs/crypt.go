@@ -0,0 +1,77 @@
+package installs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// KeySize is the length of an encryption key, for AES-256
+const KeySize = 32
+
+// ErrDecrypt means a token couldn't be decrypted, usually because the key changed
+var ErrDecrypt = errors.New("failed to decrypt token, has the encryption key changed?")
+
+// ParseKey decodes a base64 or hex encryption key, eg: the output of `openssl rand -base64 32`
+func ParseKey(encoded string) ([]byte, error) {
+	// 64 hex characters are also valid base64
+	key, err := hex.DecodeString(encoded)
+	if err != nil || len(encoded) != 2*KeySize {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil {
+		return nil, errors.New("encryption key should be base64 or hex")
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key should be %v bytes, got %v", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Cipher encrypts tokens with AES-GCM. The team id is authenticated along with
+// the token, so a token can't be moved to another installation in the file.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a cipher from a key made with ParseKey
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts a token, the result is base64 with the nonce first
+func (c *Cipher) Seal(teamID, token string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(token), []byte(teamID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a token made with Seal
+func (c *Cipher) Open(teamID, sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < c.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	nonce, ciphertext := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	token, err := c.aead.Open(nil, nonce, ciphertext, []byte(teamID))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(token), nil
+}
@@ -0,0 +1,98 @@
+package installs
+
+import (
+	"fmt"
+	"time"
+
+	"app/jsonfile"
+)
+
+// SessionsFile is the name of the file SessionStore keeps in its data dir
+const SessionsFile = "figure1_sessions.json"
+
+// Session links a slack user to their own figure 1 account. Only the bearer
+// token is kept, never their password.
+type Session struct {
+	TeamID   string    `json:"team_id"`
+	UserID   string    `json:"user_id"`
+	Email    string    `json:"email"`
+	LinkedAt time.Time `json:"linked_at"`
+
+	// Token is never written out as is, see storedSession
+	Token string `json:"-"`
+}
+
+func (s Session) key() string {
+	return sessionKey(s.TeamID, s.UserID)
+}
+
+// sessionKey is also authenticated with the token, so it can't be moved to another user
+func sessionKey(teamID, userID string) string {
+	return teamID + "/" + userID
+}
+
+// storedSession is a Session as it's saved to the file
+type storedSession struct {
+	Session
+	SealedToken string `json:"token"`
+}
+
+// SessionStore is the users that logged in to figure 1 themselves, by team and user id
+type SessionStore struct {
+	cipher *Cipher
+	table  *jsonfile.Table
+}
+
+// NewSessionStore is for tests, its sessions are forgotten once it's gone
+func NewSessionStore(cipher *Cipher) *SessionStore {
+	s := &SessionStore{cipher: cipher}
+	s.table = jsonfile.NewTable(func(key string, value interface{}) (interface{}, error) {
+		session := value.(Session)
+		sealed, err := s.cipher.Seal(key, session.Token)
+		return storedSession{Session: session, SealedToken: sealed}, err
+	})
+	return s
+}
+
+// OpenSessions reads the sessions saved in dir, decrypting their tokens
+func OpenSessions(dir string, cipher *Cipher) (*SessionStore, error) {
+	s := NewSessionStore(cipher)
+	var stored []storedSession
+	if err := s.table.Open(dir, SessionsFile, &stored); err != nil {
+		return nil, err
+	}
+	for _, session := range stored {
+		token, err := cipher.Open(session.key(), session.SealedToken)
+		if err != nil {
+			return nil, fmt.Errorf("user %v: %v", session.key(), err)
+		}
+		session.Token = token
+		s.table.Load(session.key(), session.Session)
+	}
+	return s, nil
+}
+
+// Get returns a user's session
+func (s *SessionStore) Get(teamID, userID string) (Session, bool) {
+	session, ok := s.table.Get(sessionKey(teamID, userID))
+	if !ok {
+		return Session{}, false
+	}
+	return session.(Session), true
+}
+
+// Count is how many users have linked their figure 1 account
+func (s *SessionStore) Count() int {
+	return s.table.Len()
+}
+
+// Save adds or replaces the session for session.TeamID and session.UserID
+func (s *SessionStore) Save(session Session) error {
+	return s.table.Set(session.key(), session)
+}
+
+// Delete removes a user's session, eg: when they log out or it expires.
+// It returns false if the user wasn't logged in.
+func (s *SessionStore) Delete(teamID, userID string) (bool, error) {
+	return s.table.Delete(sessionKey(teamID, userID))
+}
@@ -0,0 +1,100 @@
+// Package installs keeps the slack workspaces the app has been installed to, and
+// the figure 1 accounts they and their users look up content with, with tokens and
+// passwords encrypted at rest
+package installs
+
+import (
+	"fmt"
+	"time"
+
+	"app/jsonfile"
+)
+
+// File is the name of the file Store keeps in its data dir
+const File = "installations.json"
+
+// Installation is a workspace that installed the app with the oauth flow
+type Installation struct {
+	TeamID       string    `json:"team_id"`
+	TeamName     string    `json:"team_name"`
+	EnterpriseID string    `json:"enterprise_id,omitempty"`
+	AppID        string    `json:"app_id"`
+	BotUserID    string    `json:"bot_user_id"`
+	Scope        string    `json:"scope"`
+	InstalledBy  string    `json:"installed_by"`
+	InstalledAt  time.Time `json:"installed_at"`
+
+	// BotToken is never written out as is, see storedInstallation
+	BotToken string `json:"-"`
+}
+
+// storedInstallation is an Installation as it's saved to the file
+type storedInstallation struct {
+	Installation
+	SealedToken string `json:"bot_token"`
+}
+
+// Store is the installations, by team id
+type Store struct {
+	cipher *Cipher
+	table  *jsonfile.Table
+}
+
+// NewStore is an empty store, Open gives it a file
+func NewStore(cipher *Cipher) *Store {
+	s := &Store{cipher: cipher}
+	s.table = jsonfile.NewTable(func(teamID string, value interface{}) (interface{}, error) {
+		inst := value.(Installation)
+		sealed, err := s.cipher.Seal(teamID, inst.BotToken)
+		return storedInstallation{Installation: inst, SealedToken: sealed}, err
+	})
+	return s
+}
+
+// Open reads the installations saved in dir, decrypting their tokens
+func Open(dir string, cipher *Cipher) (*Store, error) {
+	s := NewStore(cipher)
+	var stored []storedInstallation
+	if err := s.table.Open(dir, File, &stored); err != nil {
+		return nil, err
+	}
+	for _, inst := range stored {
+		token, err := cipher.Open(inst.TeamID, inst.SealedToken)
+		if err != nil {
+			return nil, fmt.Errorf("team %v: %v", inst.TeamID, err)
+		}
+		inst.BotToken = token
+		s.table.Load(inst.TeamID, inst.Installation)
+	}
+	return s, nil
+}
+
+// Get returns the installation for a team
+func (s *Store) Get(teamID string) (Installation, bool) {
+	inst, ok := s.table.Get(teamID)
+	if !ok {
+		return Installation{}, false
+	}
+	return inst.(Installation), true
+}
+
+// List returns every installation, sorted by team id
+func (s *Store) List() []Installation {
+	values := s.table.Values()
+	list := make([]Installation, 0, len(values))
+	for _, inst := range values {
+		list = append(list, inst.(Installation))
+	}
+	return list
+}
+
+// Save adds or replaces the installation for inst.TeamID
+func (s *Store) Save(inst Installation) error {
+	return s.table.Set(inst.TeamID, inst)
+}
+
+// Delete removes a team's installation, eg: when the app is uninstalled.
+// It returns false if the team wasn't installed.
+func (s *Store) Delete(teamID string) (bool, error) {
+	return s.table.Delete(teamID)
+}
@@ -0,0 +1,138 @@
+package installs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testCipher(t *testing.T, fill byte) *Cipher {
+	key, err := ParseKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, KeySize)))
+	if err != nil {
+		t.Fatalf("Expected key to parse, got %v", err)
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatalf("Expected cipher, got %v", err)
+	}
+	return c
+}
+
+func TestStoreEncryptsTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "installs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := Open(dir, testCipher(t, 1))
+	if err != nil {
+		t.Fatalf("Expected empty store, got %v", err)
+	}
+	for _, inst := range []Installation{
+		{TeamID: "T1", TeamName: "One", BotToken: "xoxb-one", InstalledAt: time.Now()},
+		{TeamID: "T2", TeamName: "Two", BotToken: "xoxb-two", InstalledAt: time.Now()},
+	} {
+		if err := store.Save(inst); err != nil {
+			t.Fatalf("Expected %v to be saved, got %v", inst.TeamID, err)
+		}
+	}
+	if ok, err := store.Delete("T2"); !ok || err != nil {
+		t.Errorf("Expected T2 to be deleted, got %v (err: %v)", ok, err)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, File))
+	if bytes.Contains(data, []byte("xoxb-")) || !bytes.Contains(data, []byte("One")) {
+		t.Errorf("Expected the token to be encrypted in the file, got %s", data)
+	}
+
+	reopened, err := Open(dir, testCipher(t, 1))
+	if err != nil {
+		t.Fatalf("Expected store to reopen, got %v", err)
+	}
+	if inst, ok := reopened.Get("T1"); !ok || inst.BotToken != "xoxb-one" || inst.TeamName != "One" {
+		t.Errorf("Expected T1 to be decrypted, got %+v", inst)
+	}
+	if _, ok := reopened.Get("T2"); ok || len(reopened.List()) != 1 {
+		t.Errorf("Expected only T1, got %+v", reopened.List())
+	}
+
+	if _, err := Open(dir, testCipher(t, 2)); err == nil {
+		t.Errorf("Expected opening with a different key to fail")
+	}
+}
+
+func TestCipherBindsTeam(t *testing.T) {
+	c := testCipher(t, 1)
+	sealed, err := c.Seal("T1", "xoxb-one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := c.Open("T1", sealed); err != nil || token != "xoxb-one" {
+		t.Errorf("Expected token, got %q (err: %v)", token, err)
+	}
+	if _, err := c.Open("T2", sealed); err != ErrDecrypt {
+		t.Errorf("Expected a token sealed for T1 not to open for T2, got %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{base64.StdEncoding.EncodeToString(make([]byte, KeySize)), true},
+		{"0000000000000000000000000000000000000000000000000000000000000000", true},
+		{base64.StdEncoding.EncodeToString(make([]byte, 16)), false},
+		{"not a key", false},
+	}
+	for _, test := range tests {
+		if _, err := ParseKey(test.key); (err == nil) != test.ok {
+			t.Errorf("Expected %q ok to be %v, got %v", test.key, test.ok, err)
+		}
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenSessions(dir, testCipher(t, 1))
+	if err != nil {
+		t.Fatalf("Expected empty store, got %v", err)
+	}
+	for _, session := range []Session{
+		{TeamID: "T1", UserID: "U1", Email: "one@example.com", Token: "Bearer one"},
+		{TeamID: "T1", UserID: "U2", Email: "two@example.com", Token: "Bearer two"},
+	} {
+		if err := store.Save(session); err != nil {
+			t.Fatalf("Expected %v to be saved, got %v", session.UserID, err)
+		}
+	}
+	if ok, err := store.Delete("T1", "U2"); !ok || err != nil {
+		t.Errorf("Expected U2 to be deleted, got %v (err: %v)", ok, err)
+	}
+	if ok, _ := store.Delete("T2", "U1"); ok {
+		t.Errorf("Expected sessions to be kept per team")
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, SessionsFile))
+	if bytes.Contains(data, []byte("Bearer")) || !bytes.Contains(data, []byte("one@example.com")) {
+		t.Errorf("Expected the token to be encrypted in the file, got %s", data)
+	}
+
+	reopened, err := OpenSessions(dir, testCipher(t, 1))
+	if err != nil {
+		t.Fatalf("Expected store to reopen, got %v", err)
+	}
+	if session, ok := reopened.Get("T1", "U1"); !ok || session.Token != "Bearer one" || reopened.Count() != 1 {
+		t.Errorf("Expected only U1's session, got %+v (count: %v)", session, reopened.Count())
+	}
+}
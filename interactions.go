@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	actionRefresh = "refresh_preview"
+	actionDelete  = "delete_preview"
+	actionPost    = "post_preview"
+	actionCancel  = "cancel_preview"
+
+	previewActionsBlockID = "preview_actions"
+	confirmActionsBlockID = "confirm_actions"
+)
+
+// interactionPayload is the json sent in the `payload` form field when a button is clicked
+type interactionPayload struct {
+	Type        string `json:"type"`
+	TriggerID   string `json:"trigger_id"`
+	ResponseURL string `json:"response_url"`
+	Team        struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Name     string `json:"name"`
+	} `json:"user"`
+	Channel struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		BlockID  string `json:"block_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+func (p *interactionPayload) target() responseTarget {
+	return responseTarget{URL: p.ResponseURL, Team: p.Team.ID, Channel: p.Channel.ID, User: p.User.ID}
+}
+
+// previewRef is stored in button values so a preview can be found again later.
+// Owner is the username shown as the poster, OwnerID is who's allowed to delete it.
+type previewRef struct {
+	Kind    string
+	ID      string
+	Owner   string
+	OwnerID string
+}
+
+// String is a json array, since ids and usernames can contain anything
+func (ref previewRef) String() string {
+	data, _ := json.Marshal([]string{ref.Kind, ref.ID, ref.Owner, ref.OwnerID})
+	return string(data)
+}
+
+func parsePreviewRef(value string) (previewRef, error) {
+	var parts []string
+	if strings.HasPrefix(value, "[") {
+		if err := json.Unmarshal([]byte(value), &parts); err != nil {
+			return previewRef{}, fmt.Errorf("invalid preview reference %q", value)
+		}
+	} else {
+		// posted before refs were json, as `kind|id|owner|owner id`
+		parts = strings.Split(value, "|")
+		if len(parts) == 3 {
+			// posted before owner ids were stored, they can't be deleted
+			parts = append(parts, "")
+		}
+	}
+	if len(parts) != 4 || parts[0] == "" || parts[1] == "" {
+		return previewRef{}, fmt.Errorf("invalid preview reference %q", value)
+	}
+	return previewRef{parts[0], parts[1], parts[2], parts[3]}, nil
+}
+
+// previewActions are the buttons attached to every preview posted in a channel
+func previewActions(ref previewRef) Block {
+	deleteButton := newButtonElement("Delete", actionDelete, ref.String())
+	deleteButton.Style = "danger"
+	return newActionsBlock(
+		previewActionsBlockID,
+		newButtonElement("Refresh stats", actionRefresh, ref.String()),
+		deleteButton,
+	)
+}
+
+// confirmActions are the buttons on an ephemeral preview, before it's shared in the
+// channel. Their value is the preview's key in app.previews.
+func confirmActions(key string) Block {
+	postButton := newButtonElement("Post to channel", actionPost, key)
+	postButton.Style = "primary"
+	return newActionsBlock(
+		confirmActionsBlockID,
+		postButton,
+		newButtonElement("Cancel", actionCancel, key),
+	)
+}
+
+func (app *SlackApp) interactionsHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		msg := "Failed to parse body"
+		(&requestError{msg, msg, err}).handleError(req.Context(), res)
+		return
+	}
+
+	var payload interactionPayload
+	if err := json.Unmarshal([]byte(req.FormValue("payload")), &payload); err != nil {
+		msg := "Failed to parse interaction payload"
+		(&requestError{msg, msg, err}).handleError(req.Context(), res)
+		return
+	}
+
+	if payload.Type != "block_actions" {
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+
+	key := deliveryKey(payload.TriggerID, payload.ResponseURL)
+	if app.duplicate(req.Context(), "interaction", key, req.Header) {
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+
+	queued := app.queue.enqueue(newJob(req.Context(), payload.Type, func(ctx context.Context) {
+		app.handleBlockActions(ctx, &payload)
+	}))
+	if !queued {
+		app.forgetDelivery("interaction", key)
+		http.Error(res, busyMessage, http.StatusServiceUnavailable)
+		return
+	}
+
+	// ack straight away, results are sent via the `response_url`
+	res.WriteHeader(http.StatusOK)
+}
+
+func (app *SlackApp) handleBlockActions(ctx context.Context, payload *interactionPayload) {
+	for _, action := range payload.Actions {
+		switch action.ActionID {
+		case actionPost:
+			app.postPreview(ctx, payload, action.Value)
+			continue
+		case actionCancel:
+			app.cancelPreview(ctx, payload, action.Value)
+			continue
+		}
+
+		ref, err := parsePreviewRef(action.Value)
+		if err != nil {
+			loggerFrom(ctx).Error("Failed to handle action", "action", action.ActionID, "err", err)
+			continue
+		}
+
+		switch action.ActionID {
+		case actionRefresh:
+			app.refreshPreview(ctx, payload, ref)
+		case actionDelete:
+			app.deletePreview(ctx, payload, ref)
+		}
+	}
+}
+
+func (app *SlackApp) refreshPreview(ctx context.Context, payload *interactionPayload, ref previewRef) {
+	content, err := app.fetchContent(skipCache(ctx), payload.Team.ID, ref.Kind, ref.ID, ref.Owner)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to refresh %v (id: %v)", ref.Kind, ref.ID)
+		(&slackError{fig1ErrorResponse(ref.Kind, err), msg, err}).handleError(ctx, payload.ResponseURL)
+		return
+	}
+	content.Blocks = append(content.Blocks, previewActions(ref))
+	content.ResponseType = "in_channel"
+	content.ReplaceOriginal = true
+
+	if err := postToResponseURL(ctx, payload.ResponseURL, content); err != nil {
+		loggerFrom(ctx).Error("Failed to replace preview", "id", ref.ID, "err", err)
+	}
+}
+
+func (app *SlackApp) deletePreview(ctx context.Context, payload *interactionPayload, ref previewRef) {
+	// only the person that posted the preview can remove it, usernames can change so compare ids
+	if ref.OwnerID == "" || payload.User.ID != ref.OwnerID {
+		body := &SlackResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("Only @%v can delete this preview", ref.Owner),
+		}
+		if err := app.respond(ctx, payload.target(), body); err != nil {
+			loggerFrom(ctx).Error("Failed to send delete warning", "user", payload.User.ID, "err", err)
+		}
+		return
+	}
+
+	if err := postToResponseURL(ctx, payload.ResponseURL, &SlackResponse{DeleteOriginal: true}); err != nil {
+		loggerFrom(ctx).Error("Failed to delete preview", "id", ref.ID, "err", err)
+	}
+}
+
+// postPreview shares an ephemeral preview with the channel, exactly as the requester
+// saw it, then removes the ephemeral copy
+func (app *SlackApp) postPreview(ctx context.Context, payload *interactionPayload, key string) {
+	preview, ok := app.previews.take(key, payload.User.ID)
+	if !ok {
+		body := &SlackResponse{
+			ResponseType:    "ephemeral",
+			ReplaceOriginal: true,
+			Text:            previewExpiredMessage,
+		}
+		if err := postToResponseURL(ctx, payload.ResponseURL, body); err != nil {
+			loggerFrom(ctx).Error("Failed to replace expired preview", "err", err)
+		}
+		return
+	}
+
+	if err := app.respond(ctx, payload.target(), preview.content); err != nil {
+		// the post button still works, so they can try again
+		app.previews.put(key, preview)
+		loggerFrom(ctx).Error("Failed to share preview", "id", preview.ref.ID, "err", err)
+		return
+	}
+
+	app.cancelPreview(ctx, payload, key)
+}
+
+func (app *SlackApp) cancelPreview(ctx context.Context, payload *interactionPayload, key string) {
+	app.previews.remove(key)
+	if err := postToResponseURL(ctx, payload.ResponseURL, &SlackResponse{DeleteOriginal: true}); err != nil {
+		loggerFrom(ctx).Error("Failed to remove ephemeral preview", "err", err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"app/fig1"
+)
+
+// clickButton sends a block_actions interaction and waits for it to be handled
+func clickButton(t *testing.T, app *SlackApp, userID, actionID, value, responseURL string) {
+	sendClick(t, app, userID, actionID, value, responseURL)
+	app.queue.shutdown(context.Background())
+}
+
+// sendClick sends a block_actions interaction without waiting for it
+func sendClick(t *testing.T, app *SlackApp, userID, actionID, value, responseURL string) {
+	payload := map[string]interface{}{
+		"type":         "block_actions",
+		"response_url": responseURL,
+		"team":         map[string]string{"id": "T1"},
+		"user":         map[string]string{"id": userID, "username": "bob"},
+		"channel":      map[string]string{"id": "C1"},
+		"actions":      []map[string]string{{"action_id": actionID, "value": value}},
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"payload": {string(data)}}
+	req := httptest.NewRequest("POST", "/interactions", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	app.interactionsHandler(res, req)
+	if res.Code != 200 {
+		t.Errorf("Expected %v to be acknowledged, got %v", actionID, res.Code)
+	}
+}
+
+func TestInteractions(t *testing.T) {
+	ref := previewRef{contentCase, "59076d6324d11b594b2dff1d", "bob", "U1"}
+
+	tests := []struct {
+		name     string
+		userID   string
+		actionID string
+		value    string
+		expected []string
+	}{
+		{"refresh", "U2", actionRefresh, ref.String(), []string{"in_channel replace"}},
+		{"delete by owner", "U1", actionDelete, ref.String(), []string{"delete"}},
+		{"delete by someone else", "U2", actionDelete, ref.String(), []string{"ephemeral"}},
+		{"delete without an owner id", "U1", actionDelete, contentCase + "|59076d6324d11b594b2dff1d|bob", []string{"ephemeral"}},
+		{"malformed value", "U1", actionDelete, "nope", nil},
+	}
+	for _, test := range tests {
+		fake := fig1.NewFake()
+		fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "first"}
+		cached, _ := newTestCache(fake)
+		app := &SlackApp{f1: cached, queue: newJobQueue(1, 1)}
+		cached.Case(context.Background(), "59076d6324d11b594b2dff1d")
+		fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "second"}
+
+		recorder := newResponseURLRecorder()
+		clickButton(t, app, test.userID, test.actionID, test.value, recorder.URL)
+		recorder.Close()
+
+		if posts := recorder.summary(); strings.Join(posts, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Expected %v to post %v, got %v", test.name, test.expected, posts)
+		}
+		if test.actionID != actionRefresh {
+			continue
+		}
+
+		// refreshing skips the cache, so the stats are current
+		if calls := fake.Calls["case"]; calls != 2 {
+			t.Errorf("Expected refresh to fetch the case again, got %v calls", calls)
+		}
+		if data, _ := json.Marshal(recorder.bodies); !strings.Contains(string(data), "second") || !strings.Contains(string(data), actionDelete) {
+			t.Errorf("Expected the refreshed case with preview buttons, got %s", data)
+		}
+	}
+}
+
+func TestParsePreviewRef(t *testing.T) {
+	for _, ref := range []previewRef{
+		{contentUser, "ccovic", "bob", "U1"},
+		{contentUser, "a|b", "bob|U2", "U1"},
+		{contentCase, `"]`, "", "U1"},
+	} {
+		if parsed, err := parsePreviewRef(ref.String()); err != nil || parsed != ref {
+			t.Errorf("Expected %+v to round trip, got %+v (err: %v)", ref, parsed, err)
+		}
+	}
+	if parsed, err := parsePreviewRef("case|abc|bob|U1"); err != nil || parsed != (previewRef{contentCase, "abc", "bob", "U1"}) {
+		t.Errorf("Expected refs posted before they were json to be read, got %+v (err: %v)", parsed, err)
+	}
+	for _, value := range []string{"", "case", "case|", "|abc|bob|U1", "case|abc|bob|U1|extra", "[", `["case","abc","bob"]`, `["","abc","bob","U1"]`} {
+		if _, err := parsePreviewRef(value); err == nil {
+			t.Errorf("Expected %q to be invalid", value)
+		}
+	}
+}
+
+// buttonValue finds the value of a button in a message posted to a response_url
+func buttonValue(body map[string]interface{}, actionID string) string {
+	blocks, _ := body["blocks"].([]interface{})
+	for _, block := range blocks {
+		elements, _ := block.(map[string]interface{})["elements"].([]interface{})
+		for _, element := range elements {
+			if button, _ := element.(map[string]interface{}); button["action_id"] == actionID {
+				value, _ := button["value"].(string)
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+func TestPostAndCancelPreview(t *testing.T) {
+	tests := []struct {
+		name     string
+		clicks   []string
+		userID   string
+		expected []string
+	}{
+		{"post", []string{actionPost}, "U1", []string{"in_channel", "delete"}},
+		{"cancel", []string{actionCancel}, "U1", []string{"delete"}},
+		{"post after cancel", []string{actionCancel, actionPost}, "U1", []string{"delete", "ephemeral replace"}},
+		{"post by someone else", []string{actionPost}, "U2", []string{"ephemeral replace"}},
+		{"post twice", []string{actionPost, actionPost}, "U1", []string{"in_channel", "delete", "ephemeral replace"}},
+	}
+	for _, test := range tests {
+		fake := fig1.NewFake()
+		fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "first"}
+		app := &SlackApp{f1: fake}
+
+		preview := newResponseURLRecorder()
+		body := slashCommandRequestBody{TeamID: "T1", ChannelID: "C1", UserID: "U1", Username: "bob", Text: "59076d6324d11b594b2dff1d", ResponseURL: preview.URL, Preview: true}
+		app.handleCase(context.Background(), &body)
+		preview.Close()
+		if posts := preview.summary(); len(posts) != 1 || posts[0] != "ephemeral replace" {
+			t.Fatalf("Expected %v to show an ephemeral preview, got %v", test.name, posts)
+		}
+		key := buttonValue(preview.bodies[0], actionPost)
+		if key == "" || buttonValue(preview.bodies[0], actionCancel) != key {
+			t.Fatalf("Expected post and cancel buttons with the preview's key, got %v", preview.bodies[0])
+		}
+
+		// what's posted is what the requester saw, even if the case has changed since
+		fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "second"}
+		recorder := newResponseURLRecorder()
+		for _, actionID := range test.clicks {
+			app.queue = newJobQueue(1, 1)
+			clickButton(t, app, test.userID, actionID, key, recorder.URL)
+		}
+		recorder.Close()
+
+		if posts := recorder.summary(); strings.Join(posts, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Expected %v to post %v, got %v", test.name, test.expected, posts)
+		}
+		data, _ := json.Marshal(recorder.bodies)
+		if strings.Contains(string(data), "second") || fake.Calls["case"] != 1 {
+			t.Errorf("Expected %v not to fetch the case again, got %s", test.name, data)
+		}
+		if test.name == "post" && (!strings.Contains(string(data), "first") || !strings.Contains(string(data), actionRefresh)) {
+			t.Errorf("Expected the preview to be posted with its buttons, got %s", data)
+		}
+	}
+}
+
+func TestPostPreviewConcurrently(t *testing.T) {
+	fake := fig1.NewFake()
+	fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "first"}
+	app := &SlackApp{f1: fake}
+
+	preview := newResponseURLRecorder()
+	body := slashCommandRequestBody{TeamID: "T1", ChannelID: "C1", UserID: "U1", Username: "bob", Text: "59076d6324d11b594b2dff1d", ResponseURL: preview.URL, Preview: true}
+	app.handleCase(context.Background(), &body)
+	preview.Close()
+	key := buttonValue(preview.bodies[0], actionPost)
+
+	// two clicks have their own trigger ids, so both are handled, but only one posts
+	recorder := newResponseURLRecorder()
+	app.queue = newJobQueue(2, 2)
+	sendClick(t, app, "U1", actionPost, key, recorder.URL)
+	sendClick(t, app, "U1", actionPost, key, recorder.URL)
+	app.queue.shutdown(context.Background())
+	recorder.Close()
+
+	posted := 0
+	for _, post := range recorder.summary() {
+		if post == "in_channel" {
+			posted++
+		}
+	}
+	if posted != 1 {
+		t.Errorf("Expected the preview to be posted once, got %v", recorder.summary())
+	}
+
+	// a preview that couldn't be posted can be posted again
+	key, _ = app.previews.add(previewRef{contentCase, "59076d6324d11b594b2dff1d", "bob", "U1"}, &SlackResponse{Text: "first"})
+	gone := httptest.NewServer(http.NotFoundHandler())
+	app.queue = newJobQueue(1, 1)
+	clickButton(t, app, "U1", actionPost, key, gone.URL)
+	gone.Close()
+	if _, ok := app.previews.take(key, "U1"); !ok {
+		t.Errorf("Expected the preview to be kept after failing to post it")
+	}
+}
+
+func TestPreviewExpires(t *testing.T) {
+	now := time.Now()
+	store := previewStore{now: func() time.Time { return now }}
+	key, err := store.add(previewRef{contentCase, "abc", "bob", "U1"}, &SlackResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.take(key, "U2"); ok {
+		t.Errorf("Expected only the owner to take the preview")
+	}
+	if _, ok := store.take(key, "U1"); !ok {
+		t.Errorf("Expected the preview to be kept")
+	}
+	if _, ok := store.take(key, "U1"); ok {
+		t.Errorf("Expected the preview to only be taken once")
+	}
+	key, _ = store.add(previewRef{contentCase, "abc", "bob", "U1"}, &SlackResponse{})
+	now = now.Add(previewTTL)
+	if _, ok := store.take(key, "U1"); ok {
+		t.Errorf("Expected the preview to expire")
+	}
+	store.add(previewRef{contentCase, "def", "bob", "U1"}, &SlackResponse{})
+	if len(store.pending) != 1 {
+		t.Errorf("Expected expired previews to be removed, got %v", len(store.pending))
+	}
+}
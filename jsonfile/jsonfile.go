@@ -0,0 +1,170 @@
+// Package jsonfile keeps small tables, eg: installations or channel settings, in memory
+// and in a json file in the data dir, which is replaced whole after every change
+package jsonfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Encoder turns a value into what's written to the file for it, eg: with its secrets encrypted
+type Encoder func(key string, value interface{}) (interface{}, error)
+
+// Table keeps values by key. Once it has a file, every change is saved to it,
+// and changes that can't be saved are undone.
+type Table struct {
+	path   string
+	encode Encoder
+
+	mu     sync.RWMutex
+	values map[string]interface{}
+}
+
+// NewTable creates a table that's only kept in memory, until it's opened
+func NewTable(encode Encoder) *Table {
+	return &Table{encode: encode, values: map[string]interface{}{}}
+}
+
+// Open reads the file called name in dir into records (a pointer to a slice), and saves
+// the table there from now on. The file is created with the first change if it doesn't exist.
+func (t *Table) Open(dir, name string, records interface{}) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	t.path = filepath.Join(dir, name)
+
+	data, err := ioutil.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, records); err != nil {
+		return fmt.Errorf("failed to read %v: %v", t.path, err)
+	}
+	return nil
+}
+
+// Load adds a value read from the file, without saving it again
+func (t *Table) Load(key string, value interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.values[key] = value
+}
+
+// Get returns the value for key
+func (t *Table) Get(key string) (interface{}, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	value, ok := t.values[key]
+	return value, ok
+}
+
+// Len is how many values there are
+func (t *Table) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.values)
+}
+
+// Values returns every value, sorted by key
+func (t *Table) Values() []interface{} {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	values := make([]interface{}, 0, len(t.values))
+	for _, key := range t.keys() {
+		values = append(values, t.values[key])
+	}
+	return values
+}
+
+// Set adds or replaces the value for key
+func (t *Table) Set(key string, value interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous, existed := t.values[key]
+	t.values[key] = value
+	if err := t.write(); err != nil {
+		if existed {
+			t.values[key] = previous
+		} else {
+			delete(t.values, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the value for key, and returns false if there wasn't one
+func (t *Table) Delete(key string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	value, ok := t.values[key]
+	if !ok {
+		return false, nil
+	}
+	delete(t.values, key)
+	if err := t.write(); err != nil {
+		t.values[key] = value
+		return false, err
+	}
+	return true, nil
+}
+
+// keys are in order, so records don't move around the file between writes
+func (t *Table) keys() []string {
+	keys := make([]string, 0, len(t.values))
+	for key := range t.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// write replaces the file with every value's record, it's called with mu locked
+func (t *Table) write() error {
+	if t.path == "" {
+		return nil
+	}
+
+	records := make([]interface{}, 0, len(t.values))
+	for _, key := range t.keys() {
+		record, err := t.encode(key, t.values[key])
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(t.path, data)
+}
+
+// writeFile replaces path with data without leaving a partly written file behind
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,76 @@
+package jsonfile
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type record struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func TestTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var fail error
+	open := func() (*Table, []record) {
+		table := NewTable(func(key string, value interface{}) (interface{}, error) {
+			return record{key, value.(string)}, fail
+		})
+		var records []record
+		if err := table.Open(dir, "table.json", &records); err != nil {
+			t.Fatalf("Expected the table to open, got %v", err)
+		}
+		for _, r := range records {
+			table.Load(r.Key, r.Value)
+		}
+		return table, records
+	}
+
+	table, records := open()
+	if len(records) != 0 {
+		t.Errorf("Expected a new table to be empty, got %v", records)
+	}
+	table.Set("b", "two")
+	table.Set("a", "one")
+	table.Set("c", "three")
+	if ok, err := table.Delete("c"); !ok || err != nil {
+		t.Errorf("Expected c to be deleted, got %v (err: %v)", ok, err)
+	}
+	if ok, _ := table.Delete("missing"); ok {
+		t.Errorf("Expected nothing to delete")
+	}
+
+	// changes that can't be saved are undone
+	fail = errors.New("can't encode")
+	if err := table.Set("a", "changed"); err != fail {
+		t.Errorf("Expected the change to fail, got %v", err)
+	}
+	if err := table.Set("d", "four"); err != fail {
+		t.Errorf("Expected the addition to fail, got %v", err)
+	}
+	if ok, err := table.Delete("b"); ok || err != fail {
+		t.Errorf("Expected the delete to fail, got %v (err: %v)", ok, err)
+	}
+	if values := table.Values(); len(values) != 2 || values[0] != "one" || values[1] != "two" {
+		t.Errorf("Expected the failed changes to be undone, got %v", values)
+	}
+	fail = nil
+
+	table, records = open()
+	if len(records) != 2 || records[0] != (record{"a", "one"}) || records[1] != (record{"b", "two"}) || table.Len() != 2 {
+		t.Errorf("Expected a and b sorted by key after reopening, got %v", records)
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("Expected only the table's file to be left, got %v", files)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"app/logging"
+)
+
+// logger is for anything that isn't part of a request, it's configured with
+// `log_format` and `log_level` when the app starts
+var logger = logging.New(os.Stdout, logging.Text, logging.Info)
+
+// loggerFrom returns the request's logger (which adds its request id), or the app logger
+func loggerFrom(ctx context.Context) *logging.Logger {
+	return logging.FromContext(ctx, logger)
+}
+
+// withRequestID gives every request an id, which is logged with everything done
+// for it including queued jobs and figure 1 calls
+func withRequestID(path string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		id := logging.NewRequestID()
+		res.Header().Set("X-Request-ID", id)
+
+		log := logger.With("request_id", id, "path", path)
+		next.ServeHTTP(res, req.WithContext(logging.NewContext(req.Context(), log)))
+	})
+}
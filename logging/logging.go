@@ -0,0 +1,225 @@
+// Package logging is a leveled logger that writes text or json lines. Anything
+// that looks like a token, password or bearer header is redacted before it's written.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how important a log line is, lines below the logger's level are dropped
+type Level int
+
+// levels, from least to most important
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads "debug", "info", "warn" or "error"
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q", name)
+}
+
+// formats
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Logger writes log lines with a set of fields, eg: a request id. Loggers made
+// with With share their output, level and redaction with their parent.
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// output is shared between a logger and the loggers made from it
+type output struct {
+	mu       sync.Mutex
+	w        io.Writer
+	format   string
+	level    Level
+	redactor *Redactor
+	now      func() time.Time
+}
+
+// New creates a logger that writes lines at level and above in format (Text or JSON)
+func New(w io.Writer, format string, level Level) *Logger {
+	if format != JSON {
+		format = Text
+	}
+	return &Logger{out: &output{
+		w:        w,
+		format:   format,
+		level:    level,
+		redactor: NewRedactor(),
+		now:      time.Now,
+	}}
+}
+
+// Configure changes the format and level of l and every logger made from it
+func (l *Logger) Configure(format string, level Level) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	if format != JSON {
+		format = Text
+	}
+	l.out.format = format
+	l.out.level = level
+}
+
+// AddSecrets redacts these exact values (eg: passwords from the config) wherever they appear
+func (l *Logger) AddSecrets(secrets ...string) {
+	l.out.redactor.AddSecrets(secrets...)
+}
+
+// With returns a logger that adds key/value pairs to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Debug logs msg with key/value pairs, eg: log.Debug("Fetched case", "id", id)
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(Debug, msg, keyvals) }
+
+// Info logs msg with key/value pairs
+func (l *Logger) Info(msg string, keyvals ...interface{}) { l.log(Info, msg, keyvals) }
+
+// Warn logs msg with key/value pairs
+func (l *Logger) Warn(msg string, keyvals ...interface{}) { l.log(Warn, msg, keyvals) }
+
+// Error logs msg with key/value pairs
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(Error, msg, keyvals) }
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	if level < l.out.level {
+		return
+	}
+
+	redactor := l.out.redactor
+	fields := [][2]string{}
+	all := append(append([]interface{}{}, l.fields...), keyvals...)
+	for i := 0; i < len(all); i += 2 {
+		key := fmt.Sprint(all[i])
+		value := "(missing)"
+		if i+1 < len(all) {
+			value = formatValue(all[i+1])
+		}
+		fields = append(fields, [2]string{key, redactor.RedactField(key, value)})
+	}
+
+	var buf bytes.Buffer
+	timestamp := l.out.now().UTC().Format(time.RFC3339)
+	msg = redactor.Redact(msg)
+	if l.out.format == JSON {
+		line := map[string]string{"time": timestamp, "level": level.String(), "msg": msg}
+		for _, field := range fields {
+			if _, ok := line[field[0]]; ok {
+				field[0] = "field." + field[0]
+			}
+			line[field[0]] = field[1]
+		}
+		data, _ := json.Marshal(line)
+		buf.Write(data)
+	} else {
+		fmt.Fprintf(&buf, "%v %-5v %v", timestamp, strings.ToUpper(level.String()), msg)
+		for _, field := range fields {
+			fmt.Fprintf(&buf, " %v=%v", field[0], quoteText(field[1]))
+		}
+	}
+	buf.WriteByte('\n')
+	l.out.w.Write(buf.Bytes())
+}
+
+func formatValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case error:
+		return value.Error()
+	case time.Time:
+		return value.UTC().Format(time.RFC3339)
+	case fmt.Stringer:
+		return value.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// quoteText quotes values that would be hard to read (or split) in a text line
+func quoteText(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+type contextKey struct{}
+
+// NewContext returns a context that carries the logger, so async work and
+// figure 1 calls can log with the same request id
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the context's logger, or fallback if it doesn't have one
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// RequestID returns the context logger's "request_id" field, if there is one
+func RequestID(ctx context.Context) string {
+	l, ok := ctx.Value(contextKey{}).(*Logger)
+	if !ok {
+		return ""
+	}
+	for i := 0; i+1 < len(l.fields); i += 2 {
+		if l.fields[i] == "request_id" {
+			return formatValue(l.fields[i+1])
+		}
+	}
+	return ""
+}
+
+// NewRequestID generates a random id for tying log lines to a request
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger(format string, level Level) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := New(&buf, format, level)
+	l.out.now = func() time.Time { return time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC) }
+	return l, &buf
+}
+
+func TestTextOutput(t *testing.T) {
+	l, buf := newTestLogger(Text, Info)
+	l = l.With("request_id", "abc123")
+
+	l.Debug("Dropped")
+	l.Info("Fetched case", "id", "5907", "caption", "two words")
+	l.Error("Failed", "err", errors.New("figure 1 is down"), "odd")
+
+	expected := `2018-03-01T12:00:00Z INFO  Fetched case request_id=abc123 id=5907 caption="two words"
+2018-03-01T12:00:00Z ERROR Failed request_id=abc123 err="figure 1 is down" odd=(missing)
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, buf.String())
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	l, buf := newTestLogger(JSON, Debug)
+	l.With("request_id", "abc123").Warn("Serving stale content", "key", "case:5907", "msg", "clash")
+
+	var line map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a json line, got %v (%v)", buf.String(), err)
+	}
+	expected := map[string]string{
+		"time":       "2018-03-01T12:00:00Z",
+		"level":      "warn",
+		"msg":        "Serving stale content",
+		"request_id": "abc123",
+		"key":        "case:5907",
+		"field.msg":  "clash",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("Expected %v to be %q, got %q", key, value, line[key])
+		}
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	l, buf := newTestLogger(Text, Debug)
+	l.AddSecrets("hunter2", "ab")
+
+	tests := []struct {
+		msg     string
+		keyvals []interface{}
+		secret  string
+	}{
+		{"Login failed for hunter2", nil, "hunter2"},
+		{"Slack api failed", []interface{}{"body", "token=xoxb-1234-abcd&channel=C1"}, "xoxb-1234-abcd"},
+		{"Request", []interface{}{"header", "Bearer eyJhbGciOi.eyJleHAiOjF9.c2lnbmF0dXJl"}, "eyJhbGciOi"},
+		{"Figure 1 token", []interface{}{"value", "eyJhbGciOi.eyJleHAiOjF9.c2lnbmF0dXJl"}, "c2lnbmF0dXJl"},
+		{"Decoded body", []interface{}{"body", `{"password":"letmein","email":"a@example.com"}`}, "letmein"},
+		{"Config", []interface{}{"signing_secret", "8f742231b10e"}, "8f742231b10e"},
+		{"Posting", []interface{}{"url", "https://hooks.slack.com/commands/T1/123/secretpart"}, "secretpart"},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		l.Info(test.msg, test.keyvals...)
+		if strings.Contains(buf.String(), test.secret) || !strings.Contains(buf.String(), Redacted) {
+			t.Errorf("Expected %q to be redacted, got %v", test.secret, buf.String())
+		}
+	}
+
+	// short secrets would mask too much
+	buf.Reset()
+	l.Info("about", "email", "a@example.com")
+	if strings.Contains(buf.String(), Redacted) {
+		t.Errorf("Expected nothing to be redacted, got %v", buf.String())
+	}
+}
+
+func TestContext(t *testing.T) {
+	fallback, _ := newTestLogger(Text, Info)
+	ctx := context.Background()
+	if FromContext(ctx, fallback) != fallback || RequestID(ctx) != "" {
+		t.Errorf("Expected the fallback logger and no request id without a logger in the context")
+	}
+
+	l := fallback.With("request_id", "abc123")
+	ctx = NewContext(ctx, l)
+	if FromContext(ctx, fallback) != l || RequestID(ctx) != "abc123" {
+		t.Errorf("Expected the context's logger and request id, got %v", RequestID(ctx))
+	}
+
+	if level, err := ParseLevel("WARN"); err != nil || level != Warn {
+		t.Errorf("Expected warn level, got %v (err: %v)", level, err)
+	}
+}
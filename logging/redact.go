@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in log lines
+const Redacted = "[redacted]"
+
+var (
+	// field keys whose values are always secret
+	secretKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|authorization|api_?key)`)
+
+	secretPatterns = []struct {
+		pattern *regexp.Regexp
+		replace string
+	}{
+		// slack tokens, eg: xoxb-123-abc
+		{regexp.MustCompile(`\b(xox[abposre])-[A-Za-z0-9-]+`), "$1-" + Redacted},
+		// authorization headers
+		{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`), "$1 " + Redacted},
+		// response_urls and webhooks work without any other credentials
+		{regexp.MustCompile(`(https://hooks\.slack\.com/)[^\s"]+`), "${1}" + Redacted},
+		// jwts, eg: figure 1 tokens
+		{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Redacted},
+		// form values and json, eg: token=abc or "password":"abc"
+		{regexp.MustCompile(`(?i)((?:password|passwd|secret|token)"?\s*[:=]\s*"?)[^"&\s,}]+`), "${1}" + Redacted},
+	}
+)
+
+// Redactor masks secrets that match known patterns, and exact values it's been given
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// NewRedactor creates a redactor that only knows the built in patterns
+func NewRedactor() *Redactor {
+	return &Redactor{}
+}
+
+// AddSecrets adds exact values to redact, empty and very short values are ignored
+// since they'd mask too much
+func (r *Redactor) AddSecrets(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, secret := range secrets {
+		if len(secret) >= 4 {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+}
+
+// Redact masks every secret in text
+func (r *Redactor) Redact(text string) string {
+	r.mu.RLock()
+	for _, secret := range r.secrets {
+		text = strings.Replace(text, secret, Redacted, -1)
+	}
+	r.mu.RUnlock()
+
+	for _, p := range secretPatterns {
+		text = p.pattern.ReplaceAllString(text, p.replace)
+	}
+	return text
+}
+
+// RedactField masks the whole value if the key sounds secret, otherwise just the secrets in it
+func (r *Redactor) RedactField(key, value string) string {
+	if value != "" && secretKey.MatchString(key) {
+		return Redacted
+	}
+	return r.Redact(value)
+}
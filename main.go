@@ -1,67 +1,295 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-)
 
-const (
-	port    = "3400"
-	address = ":" + port
+	"app/cache"
+	"app/fig1"
+	"app/installs"
+	"app/logging"
+	"app/settings"
+	"app/slackapi"
 )
 
-// SlackApp contains figure1 and slack tokens/secrets
+// SlackApp contains figure1 and slack tokens/secrets, see config.go for how it's loaded
 type SlackApp struct {
-	Email       string
-	Password    string
-	BearerToken string
+	// server settings, every route is under `base_path` if it's set. `public_url` is
+	// where people can reach it, eg: for `/fig1 login` links
+	ListenAddress string   `json:"listen_address"`
+	BasePath      string   `json:"base_path"`
+	PublicURL     string   `json:"public_url"`
+	ReadTimeout   duration `json:"read_timeout"`
+	WriteTimeout  duration `json:"write_timeout"`
+	// how long to wait for in-flight requests and queued jobs when stopping
+	ShutdownTimeout duration `json:"shutdown_timeout"`
+	stopping        int32
+
+	Email    string
+	Password string
+
+	// figure 1 base urls, the defaults are the production ones
+	Fig1AppURL  string   `json:"figure1_app_url"`
+	Fig1APIURL  string   `json:"figure1_api_url"`
+	Fig1Timeout duration `json:"figure1_timeout"`
+	f1          fig1.Client
+	auth        *authMonitor
+	// workspaces with their own figure 1 account, and users that logged in with
+	// `/fig1 login`, if there's an `encryption_key`
+	tenants     *figure1Tenants
+	sessions    *installs.SessionStore
+	userClients sessionClients
+	// login links that have been opened, so each only works in one browser
+	loginLinks *dedupStore
+
+	// bearer token for the `/admin/*` endpoints, which are disabled if it's empty
+	AdminToken string `json:"admin_token"`
+
+	// figure 1 responses are cached in memory, or in `data_dir` with the "disk" backend.
+	// `cache_ttls` is keyed by content type
+	CacheBackend string            `json:"cache_backend"`
+	CacheSize    int               `json:"cache_size"`
+	CacheTTLs    map[string]string `json:"cache_ttls"`
+	DataDir      string            `json:"data_dir"`
+	cache        cache.Backend
+	ttls         map[string]time.Duration
+
+	// "text" or "json", and "debug", "info", "warn" or "error"
+	LogFormat string `json:"log_format"`
+	LogLevel  string `json:"log_level"`
+
+	// background workers for slash commands, events and interactions
+	Workers    int `json:"workers"`
+	QueueDepth int `json:"queue_depth"`
+	queue      *jobQueue
+	// recent deliveries, so slack's retries are acknowledged without being handled again
+	dedup *dedupStore
 
 	// slack tokens/secrets
 	OAuthAccessToken  string `json:"oauth_access_token"`
+	SigningSecret     string `json:"signing_secret"`
 	VerificationToken string `json:"verification_token"`
+	// accept the legacy verification token while migrating to signed requests
+	AllowLegacyToken bool `json:"allow_legacy_token"`
+	// receive requests over a websocket opened with the app-level token, instead of
+	// (or as well as) on the public routes
+	SocketMode bool   `json:"socket_mode"`
+	AppToken   string `json:"app_token"`
+
+	// web api used for unfurls, and for posting when a response_url doesn't work
+	SlackAPIURL string `json:"slack_api_url"`
+	slack       *slackapi.Client
+
+	// workspaces that installed the app with oauth, each with their own bot token
+	ClientID         string `json:"client_id"`
+	ClientSecret     string `json:"client_secret"`
+	OAuthRedirectURL string `json:"oauth_redirect_url"`
+	EncryptionKey    string `json:"encryption_key"`
+	installs         *installs.Store
+
+	// message format ("legacy" or "blocks"), can be overridden per workspace
+	MessageFormat string                       `json:"message_format"`
+	Workspaces    map[string]workspaceSettings `json:"workspaces"`
+
+	// channels that show previews to the requester by default. Changes made with
+	// `/fig1 preview` are saved in `data_dir`, and override these.
+	Channels   map[string]*channelSettings `json:"channels"`
+	channelsMu sync.RWMutex
+	channels   *settings.Channels
+
+	// previews waiting for the requester to post or cancel them
+	previews previewStore
+}
+
+// workspaceSettings are options for a single slack workspace, keyed by team id
+type workspaceSettings struct {
+	MessageFormat string `json:"message_format"`
+}
+
+// channelSettings are options for a single slack channel, keyed by channel id
+type channelSettings struct {
+	Preview bool `json:"preview"`
 }
 
 func main() {
-	slackApp := newSlackApp()
-	if err := slackApp.getBearerToken(); err != nil {
-		log.Fatal("Failed to get bearer token, credentials are probably incorrect")
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfig(os.Args[2:], os.Getenv, os.Stdout))
+	}
+
+	slackApp := newSlackApp(os.Args[1:])
+	if err := slackApp.f1.Login(context.Background()); err != nil {
+		// keep serving, the auth monitor retries in the background
+		logger.Error("Failed to get bearer token, starting in degraded mode", "err", err)
 	}
 
 	mux := http.NewServeMux()
 
 	// add routes
-	mux.HandleFunc("/case", slackApp.slashCommandHandler)
-	mux.HandleFunc("/user", slackApp.slashCommandHandler)
-	mux.HandleFunc("/collection", slackApp.slashCommandHandler)
+	handle := func(path string, handler http.Handler) {
+		mux.Handle(path, withRequestID(path, instrument(path, handler)))
+	}
+	for _, route := range slackApp.slackRoutes() {
+		handle(route.path, slackApp.verifySlackRequest(route.handler))
+	}
+	handle("/slack/install", http.HandlerFunc(slackApp.installHandler))
+	handle("/slack/oauth/callback", http.HandlerFunc(slackApp.oauthCallbackHandler))
+	handle(figure1LoginPath, http.HandlerFunc(slackApp.figure1LoginHandler))
+	handle("/oembed", http.HandlerFunc(slackApp.oembedHandler))
+	handle("/healthz", http.HandlerFunc(slackApp.healthzHandler))
+	handle("/readyz", http.HandlerFunc(slackApp.readyzHandler))
+	handle("/metrics", registry.Handler())
+	handle("/admin/status", slackApp.requireAdmin(http.HandlerFunc(slackApp.statusHandler)))
+	handle("/admin/cache", slackApp.requireAdmin(http.HandlerFunc(slackApp.purgeCacheHandler)))
+	handle("/admin/figure1-credentials", slackApp.requireAdmin(http.HandlerFunc(slackApp.figure1CredentialsHandler)))
+	handle("/admin/installations", slackApp.requireAdmin(http.HandlerFunc(slackApp.installationsHandler)))
+	handle("/admin/dead-letters", slackApp.requireAdmin(http.HandlerFunc(slackApp.deadLettersHandler)))
+	handle("/admin/dead-letters/replay", slackApp.requireAdmin(http.HandlerFunc(slackApp.replayDeadLetterHandler)))
+
+	var handler http.Handler = mux
+	if slackApp.BasePath != "" {
+		root := http.NewServeMux()
+		root.Handle(slackApp.BasePath+"/", http.StripPrefix(slackApp.BasePath, mux))
+		handler = root
+	}
 
 	server := &http.Server{
-		Addr:           address,
-		Handler:        mux,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		Addr:           slackApp.ListenAddress,
+		Handler:        handler,
+		ReadTimeout:    time.Duration(slackApp.ReadTimeout),
+		WriteTimeout:   time.Duration(slackApp.WriteTimeout),
 		MaxHeaderBytes: 1 << 20,
 	}
 
-	fmt.Println("Figure 1 slack app listening on " + address)
+	logger.Info("Figure 1 slack app listening", "address", slackApp.ListenAddress, "base_path", slackApp.BasePath)
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	stopSocketMode := func() {}
+	if slackApp.SocketMode {
+		stopSocketMode = slackApp.startSocketMode()
 	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	logger.Info("Shutting down", "signal", sig)
+	stopSocketMode()
+	slackApp.shutdown(server)
 }
 
-func newSlackApp() SlackApp {
-	file, err := os.Open("conf.json")
+// shutdown stops accepting requests, then waits for in-flight ones, queued jobs
+// (eg: previews that are still being fetched) and response_url retries until the grace period is up
+func (app *SlackApp) shutdown(server *http.Server) {
+	atomic.StoreInt32(&app.stopping, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(app.ShutdownTimeout))
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("Failed to finish in-flight requests", "err", err)
+	}
+	if err := app.queue.shutdown(ctx); err != nil {
+		logger.Error("Gave up on queued jobs", "count", app.queue.depth(), "err", err)
+	}
+	if err := retryingDeliveries.wait(ctx); err != nil {
+		logger.Error("Gave up on response_url retries", "count", retryingDeliveries.count(), "err", err)
+	}
+	if closer, ok := app.cache.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("Failed to close cache", "err", err)
+		}
+	}
+}
+
+func newSlackApp(args []string) *SlackApp {
+	app, path, err := loadConfig(args, os.Getenv)
 	if err != nil {
 		log.Fatal(err)
 	}
-	decoder := json.NewDecoder(file)
-	app := SlackApp{}
-	if err = decoder.Decode(&app); err != nil {
-		log.Fatal("error loading config.json", err)
+	if problems := app.validateConfig(); len(problems) > 0 {
+		log.Fatalf("invalid config (run `check-config` for details): %v", strings.Join(problems, "; "))
+	}
+	level, _ := logging.ParseLevel(app.LogLevel)
+	logger.Configure(app.LogFormat, level)
+	logger.AddSecrets(app.Password, app.OAuthAccessToken, app.SigningSecret, app.VerificationToken, app.AdminToken, app.ClientSecret, app.EncryptionKey, app.AppToken)
+	if path != "" {
+		logger.Info("Loaded config", "file", path)
+	}
+	app.ttls, _ = parseCacheTTLs(app.CacheTTLs)
+
+	switch app.CacheBackend {
+	case "memory":
+		app.cache = cache.NewMemory(app.CacheSize)
+	case "disk":
+		disk, err := cache.OpenDisk(app.DataDir)
+		if err != nil {
+			log.Fatal("error opening cache ", err)
+		}
+		go compactCache(disk, cacheCompactInterval)
+		app.cache = disk
+	}
+
+	var cipher *installs.Cipher
+	if app.EncryptionKey != "" {
+		key, _ := installs.ParseKey(app.EncryptionKey)
+		if cipher, err = installs.NewCipher(key); err != nil {
+			log.Fatal("error creating cipher ", err)
+		}
+	}
+	if app.ClientID != "" {
+		if app.installs, err = installs.Open(app.DataDir, cipher); err != nil {
+			log.Fatal("error opening installations ", err)
+		}
+		logger.Info("Loaded installations", "count", len(app.installs.List()))
+	}
+
+	if app.DataDir != "" {
+		if app.channels, err = settings.OpenChannels(app.DataDir); err != nil {
+			log.Fatal("error opening channel settings ", err)
+		}
+		logger.Info("Loaded channel settings", "count", app.channels.Count())
+	}
+
+	app.queue = newJobQueue(app.Workers, app.QueueDepth)
+	registerQueueMetrics(app.queue)
+	app.dedup = newDedupStore(dedupTTL, maxDedupKeys)
+	app.auth = newAuthMonitor(func(ctx context.Context) error {
+		return app.f1.Login(ctx)
+	})
+	client := app.newFigure1Client(app.Email, app.Password, app.auth.observe)
+	app.f1 = newCachedClient(client, app.cache, app.ttls)
+	if cipher != nil {
+		store, err := installs.OpenCredentials(app.DataDir, cipher)
+		if err != nil {
+			log.Fatal("error opening figure 1 credentials ", err)
+		}
+		app.loadFigure1Tenants(store)
+		logger.Info("Loaded workspace figure 1 credentials", "count", len(store.List()))
+
+		if app.sessions, err = installs.OpenSessions(app.DataDir, cipher); err != nil {
+			log.Fatal("error opening figure 1 sessions ", err)
+		}
+		logger.Info("Loaded figure 1 sessions", "count", app.sessions.Count())
+		app.loginLinks = newDedupStore(loginLinkTTL, maxDedupKeys)
 	}
+	app.slack = slackapi.New(slackapi.Config{
+		Token:   app.OAuthAccessToken,
+		BaseURL: app.SlackAPIURL,
+		OnCall: func(ctx context.Context, method string, err error) {
+			slackAPICallsTotal.Inc(method, resultLabel(err))
+		},
+	})
 	return app
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"app/metrics"
+)
+
+// registry has every metric, served on `/metrics`
+var registry = metrics.NewRegistry()
+
+var (
+	requestsTotal = registry.NewCounter("fig1_slack_requests_total",
+		"Requests by route and response status.", "path", "status")
+	requestDuration = registry.NewHistogram("fig1_slack_request_duration_seconds",
+		"How long requests took to respond to, by route.", metrics.DefaultBuckets, "path")
+	commandsTotal = registry.NewCounter("fig1_slack_commands_total",
+		"Slash commands by subcommand.", "command")
+	jobsTotal = registry.NewCounter("fig1_slack_jobs_total",
+		"Background jobs by name and outcome (done, expired or panicked).", "job", "outcome")
+	duplicatesTotal = registry.NewCounter("fig1_slack_duplicates_total",
+		"Retried slack deliveries that were acknowledged without being handled again, by kind (command, event or interaction).", "kind")
+
+	figure1RequestDuration = registry.NewHistogram("fig1_slack_figure1_request_duration_seconds",
+		"Figure 1 request latency by endpoint and status, which is 0 if there wasn't a response.", metrics.DefaultBuckets, "endpoint", "status")
+	figure1LoginsTotal = registry.NewCounter("fig1_slack_figure1_logins_total",
+		"Figure 1 token refreshes by result (ok or error).", "result")
+	cacheLookupsTotal = registry.NewCounter("fig1_slack_cache_lookups_total",
+		"Cache lookups by content type and result (hit, miss or stale).", "kind", "result")
+
+	responseURLDeliveries = registry.NewCounter("fig1_slack_response_url_deliveries_total",
+		"Posts to slack response_urls by status, which is 0 if there wasn't a response.", "status")
+	deadLettersTotal = registry.NewCounter("fig1_slack_dead_letters_total",
+		"Response_url deliveries that were given up on, by whether the error was retryable.", "retryable")
+	deadLettersWaiting = registry.NewGaugeFunc("fig1_slack_dead_letters",
+		"Failed response_url deliveries waiting to be replayed or discarded.", func() float64 {
+			return float64(deadLetters.count())
+		})
+	responseURLRetrying = registry.NewGaugeFunc("fig1_slack_response_url_retries",
+		"Response_url deliveries being retried in the background.", func() float64 {
+			return float64(retryingDeliveries.count())
+		})
+	installsTotal = registry.NewCounter("fig1_slack_installs_total",
+		"Workspaces installing the app with oauth, by result (ok or error).", "result")
+	userLoginsTotal = registry.NewCounter("fig1_slack_user_logins_total",
+		"Slack users logging in to their own figure 1 account, by result (ok, unauthorized or error).", "result")
+	slackAPICallsTotal = registry.NewCounter("fig1_slack_web_api_calls_total",
+		"Slack web api calls by method and result (ok or error).", "method", "result")
+)
+
+// registerQueueMetrics adds gauges for the job queue, which is created with the app
+func registerQueueMetrics(q *jobQueue) {
+	registry.NewGaugeFunc("fig1_slack_queue_depth", "Jobs waiting for a worker.", func() float64 {
+		return float64(q.depth())
+	})
+	registry.NewGaugeFunc("fig1_slack_queue_capacity", "Jobs that can wait before new ones are rejected.", func() float64 {
+		return float64(q.capacity())
+	})
+}
+
+func observeFigure1Request(ctx context.Context, endpoint string, status int, elapsed time.Duration) {
+	figure1RequestDuration.Observe(elapsed.Seconds(), endpoint, strconv.Itoa(status))
+	loggerFrom(ctx).Debug("Figure 1 request", "endpoint", endpoint, "status", status, "elapsed", elapsed)
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// statusRecorder remembers the status a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// instrument counts requests to a route, path should be the route rather than
+// the request's path so there's a fixed number of series
+func instrument(path string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		next.ServeHTTP(recorder, req)
+
+		requestsTotal.Inc(path, strconv.Itoa(recorder.status))
+		requestDuration.Observe(time.Since(start).Seconds(), path)
+	})
+}
@@ -0,0 +1,289 @@
+// Package metrics has counters, gauges and histograms that are exposed in the
+// prometheus text format, see https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets (in seconds) for request latencies
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and writes them out
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// desc is what every metric has, labels are the names of its labels
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) writeHeader(w io.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", d.name, help, d.name, d.kind)
+}
+
+// seriesKey identifies one set of label values
+func (d desc) seriesKey(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %v expects labels %v, got %v", d.name, d.labels, values))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels writes `{a="1",b="2"}`, with any extra label (eg: `le`) at the end
+func (d desc) formatLabels(values []string, extra ...string) string {
+	var pairs []string
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i, name := range d.labels {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, name, escape.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, extra[i], escape.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: " + name + " is already registered")
+	}
+	r.metrics[name] = m
+}
+
+// WriteTo writes every metric in the text format, sorted by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.write(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// Handler serves the metrics, eg: on `/metrics`
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(res)
+	})
+}
+
+// Counter is a value that only goes up, eg: the number of requests
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is the value for one set of label values
+type series struct {
+	labels []string
+	value  float64
+}
+
+// NewCounter registers a counter with the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter", labels}, series: map[string]*series{}}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds 1 to the series for the label values
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v (which can't be negative) to the series for the label values
+func (c *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		panic("metrics: counters can't go down")
+	}
+	key := c.seriesKey(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{labels: labels}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Value is the current value for the label values, mostly for tests
+func (c *Counter) Value(labels ...string) float64 {
+	key := c.seriesKey(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.writeHeader(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%v%v %v\n", c.name, c.formatLabels(s.labels), formatValue(s.value))
+	}
+}
+
+// Gauge is a value that's computed when the metrics are written, eg: the queue depth
+type Gauge struct {
+	desc
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge without labels that calls value every time it's written
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help, kind: "gauge"}, value: value}
+	r.register(name, g)
+	return g
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%v %v\n", g.name, formatValue(g.value()))
+}
+
+// Histogram counts observations (eg: request durations) in buckets
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram, buckets are upper bounds in increasing order
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, "histogram", labels},
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+	r.register(name, h)
+	return h
+}
+
+// Observe records a value for the label values
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := h.seriesKey(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: labels, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Count is the number of observations for the label values, mostly for tests
+func (h *Histogram) Count(labels ...string) uint64 {
+	key := h.seriesKey(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, h.formatLabels(s.labels, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, h.formatLabels(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.name, h.formatLabels(s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", h.name, h.formatLabels(s.labels), s.count)
+	}
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*series:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*histogramSeries:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
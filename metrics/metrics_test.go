@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests by path.", "path", "status")
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{.1, 1}, "endpoint")
+	depth := 3
+	r.NewGaugeFunc("queue_depth", "Queued jobs.", func() float64 { return float64(depth) })
+
+	requests.Inc("/fig1", "200")
+	requests.Inc("/fig1", "200")
+	requests.Inc("/events", `5"0"3`)
+	latency.Observe(.05, "case")
+	latency.Observe(.5, "case")
+	latency.Observe(2, "case")
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{endpoint="case",le="0.1"} 1
+latency_seconds_bucket{endpoint="case",le="1"} 2
+latency_seconds_bucket{endpoint="case",le="+Inf"} 3
+latency_seconds_sum{endpoint="case"} 2.55
+latency_seconds_count{endpoint="case"} 3
+# HELP queue_depth Queued jobs.
+# TYPE queue_depth gauge
+queue_depth 3
+# HELP requests_total Requests by path.
+# TYPE requests_total counter
+requests_total{path="/events",status="5\"0\"3"} 1
+requests_total{path="/fig1",status="200"} 2
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, buf.String())
+	}
+
+	if requests.Value("/fig1", "200") != 2 || latency.Count("case") != 3 {
+		t.Errorf("Expected 2 requests and 3 observations, got %v and %v", requests.Value("/fig1", "200"), latency.Count("case"))
+	}
+
+	res := httptest.NewRecorder()
+	r.Handler().ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain; version=0.0.4") || res.Body.String() != expected {
+		t.Errorf("Expected handler to serve the text format, got %v:\n%v", res.Header().Get("Content-Type"), res.Body.String())
+	}
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests.", "path")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for missing label values")
+		}
+	}()
+	c.Inc()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"app/installs"
+	"app/slackapi"
+)
+
+const (
+	// installScopes are the bot scopes asked for when a workspace installs the app
+	installScopes = "commands,chat:write,links:read,links:write"
+
+	// oauthStateCookie ties the callback to the browser that started the install
+	oauthStateCookie = "fig1_oauth_state"
+	// oauthStateTTL is how long someone has to approve the install in slack
+	oauthStateTTL = 10 * time.Minute
+)
+
+var errInvalidOAuthState = errors.New("invalid oauth state")
+
+// slackFor is the web api client for a workspace, using the bot token it was installed
+// with, or `oauth_access_token` for the workspace in the config. It's nil if there's no token.
+func (app *SlackApp) slackFor(teamID string) *slackapi.Client {
+	if app.slack == nil {
+		return nil
+	}
+	if app.installs != nil {
+		if inst, ok := app.installs.Get(teamID); ok {
+			return app.slack.WithToken(inst.BotToken)
+		}
+	}
+	if app.OAuthAccessToken == "" {
+		return nil
+	}
+	return app.slack
+}
+
+// installHandler sends the user to slack to approve installing the app, eg: `GET /slack/install`
+func (app *SlackApp) installHandler(res http.ResponseWriter, req *http.Request) {
+	if app.installs == nil {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return
+	}
+	if req.Method != http.MethodGet {
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nonce, state, err := app.newOAuthState(time.Now())
+	if err != nil {
+		loggerFrom(req.Context()).Error("Failed to create oauth state", "err", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.OAuthRedirectURL, "https://"),
+	})
+
+	params := url.Values{
+		"client_id": {app.ClientID},
+		"scope":     {installScopes},
+		"state":     {state},
+	}
+	if app.OAuthRedirectURL != "" {
+		params.Set("redirect_uri", app.OAuthRedirectURL)
+	}
+	http.Redirect(res, req, app.slack.AuthorizeURL(params), http.StatusFound)
+}
+
+// oauthCallbackHandler is where slack sends the user back to after approving (or
+// cancelling) the install, eg: `GET /slack/oauth/callback?code=...&state=...`
+func (app *SlackApp) oauthCallbackHandler(res http.ResponseWriter, req *http.Request) {
+	if app.installs == nil {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return
+	}
+	if req.Method != http.MethodGet {
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log := loggerFrom(req.Context())
+	query := req.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		log.Info("Install cancelled", "reason", reason)
+		http.Error(res, "The Figure 1 app wasn't installed", http.StatusBadRequest)
+		return
+	}
+
+	cookie, err := req.Cookie(oauthStateCookie)
+	if err != nil || app.verifyOAuthState(query.Get("state"), cookie.Value, time.Now()) != nil {
+		log.Warn("Rejected oauth callback with an invalid state")
+		http.Error(res, "This install link has expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(res, &http.Cookie{Name: oauthStateCookie, Path: "/", MaxAge: -1})
+
+	code := query.Get("code")
+	if code == "" {
+		http.Error(res, "Missing code", http.StatusBadRequest)
+		return
+	}
+
+	access, err := app.slack.OAuthV2Access(req.Context(), app.ClientID, app.ClientSecret, code, app.OAuthRedirectURL)
+	if err == nil && (access.TokenType != "bot" || access.AccessToken == "" || access.Team.ID == "") {
+		err = fmt.Errorf("expected a bot token for a team, got a %q token for team %q", access.TokenType, access.Team.ID)
+	}
+	if err != nil {
+		installsTotal.Inc("error")
+		log.Error("Failed to exchange oauth code", "err", err)
+		http.Error(res, "Failed to install the Figure 1 app, please try again", http.StatusBadGateway)
+		return
+	}
+
+	inst := installs.Installation{
+		TeamID:      access.Team.ID,
+		TeamName:    access.Team.Name,
+		AppID:       access.AppID,
+		BotUserID:   access.BotUserID,
+		Scope:       access.Scope,
+		InstalledBy: access.AuthedUser.ID,
+		InstalledAt: time.Now(),
+		BotToken:    access.AccessToken,
+	}
+	if access.Enterprise != nil {
+		inst.EnterpriseID = access.Enterprise.ID
+	}
+	if err := app.installs.Save(inst); err != nil {
+		installsTotal.Inc("error")
+		log.Error("Failed to save installation", "team", inst.TeamID, "err", err)
+		http.Error(res, "Failed to install the Figure 1 app, please try again", http.StatusInternalServerError)
+		return
+	}
+
+	installsTotal.Inc("ok")
+	log.Info("Installed", "team", inst.TeamID, "team_name", inst.TeamName, "user", inst.InstalledBy)
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(res, "The Figure 1 app has been installed in %v, try `/fig1 help` in any channel.\n", inst.TeamName)
+}
+
+// uninstall forgets a workspace's bot token once it's been revoked
+func (app *SlackApp) uninstall(teamID string) error {
+	if app.installs == nil {
+		return nil
+	}
+	_, err := app.installs.Delete(teamID)
+	return err
+}
+
+// newOAuthState is a random nonce, which is also set as a cookie, and a state
+// of `timestamp.nonce.signature`, so the callback doesn't need any server side storage
+func (app *SlackApp) newOAuthState(now time.Time) (string, string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", "", err
+	}
+	nonce := hex.EncodeToString(data)
+	payload := strconv.FormatInt(now.Unix(), 10) + "." + nonce
+	return nonce, payload + "." + app.signOAuthState(payload), nil
+}
+
+func (app *SlackApp) signOAuthState(payload string) string {
+	return signState(app.ClientSecret, payload)
+}
+
+// signState is the hex hmac of a state that's sent through slack or a browser and back
+func signState(key, payload string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (app *SlackApp) verifyOAuthState(state, nonce string, now time.Time) error {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 || parts[1] != nonce {
+		return errInvalidOAuthState
+	}
+	expected := app.signOAuthState(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return errInvalidOAuthState
+	}
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || now.Sub(time.Unix(issued, 0)) > oauthStateTTL {
+		return errInvalidOAuthState
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"app/installs"
+	"app/slackapi"
+)
+
+// fakeSlackOAuth exchanges the code "good-code" for a bot token for T1
+func fakeSlackOAuth(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/oauth.v2.access", func(res http.ResponseWriter, req *http.Request) {
+		user, password, _ := req.BasicAuth()
+		if user != "client-id" || password != "client-secret" {
+			json.NewEncoder(res).Encode(slackapi.Response{Error: "invalid_client_id"})
+			return
+		}
+		if req.FormValue("code") != "good-code" {
+			json.NewEncoder(res).Encode(slackapi.Response{Error: "invalid_code"})
+			return
+		}
+		res.Write([]byte(`{"ok":true,"access_token":"xoxb-t1","token_type":"bot","scope":"commands,chat:write","bot_user_id":"UBOT","app_id":"A1","team":{"id":"T1","name":"One"},"authed_user":{"id":"U1"}}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestOAuthInstall(t *testing.T) {
+	server := fakeSlackOAuth(t)
+	defer server.Close()
+
+	cipher, _ := installs.NewCipher(make([]byte, installs.KeySize))
+	app := &SlackApp{
+		ClientID:         "client-id",
+		ClientSecret:     "client-secret",
+		OAuthAccessToken: "xoxb-default",
+		slack:            slackapi.New(slackapi.Config{Token: "xoxb-default", BaseURL: server.URL + "/api"}),
+		installs:         installs.NewStore(cipher),
+	}
+
+	// install redirects to slack with a signed state, and sets the nonce as a cookie
+	res := httptest.NewRecorder()
+	app.installHandler(res, httptest.NewRequest("GET", "/slack/install", nil))
+	location, _ := url.Parse(res.Header().Get("Location"))
+	if res.Code != http.StatusFound || location.Path != "/oauth/v2/authorize" || location.Query().Get("client_id") != "client-id" {
+		t.Fatalf("Expected a redirect to slack, got %v %v", res.Code, location)
+	}
+	state := location.Query().Get("state")
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || !strings.Contains(state, cookies[0].Value) {
+		t.Fatalf("Expected the state's nonce as a cookie, got %v", cookies)
+	}
+
+	callback := func(query string, cookie *http.Cookie) int {
+		req := httptest.NewRequest("GET", "/slack/oauth/callback?"+query, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		res := httptest.NewRecorder()
+		app.oauthCallbackHandler(res, req)
+		return res.Code
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		cookie *http.Cookie
+		status int
+	}{
+		{"cancelled", "error=access_denied", cookies[0], 400},
+		{"no cookie", "code=good-code&state=" + state, nil, 400},
+		{"forged state", "code=good-code&state=" + strings.Replace(state, ".", ".0", 1), cookies[0], 400},
+		{"bad code", "code=bad-code&state=" + state, cookies[0], 502},
+		{"installed", "code=good-code&state=" + state, cookies[0], 200},
+	}
+	for _, test := range tests {
+		if status := callback(test.query, test.cookie); status != test.status {
+			t.Errorf("Expected %v to be %v, got %v", test.name, test.status, status)
+		}
+	}
+
+	inst, ok := app.installs.Get("T1")
+	if !ok || inst.BotToken != "xoxb-t1" || inst.BotUserID != "UBOT" || inst.InstalledBy != "U1" {
+		t.Errorf("Expected T1 to be installed, got %+v", inst)
+	}
+	if client := app.slackFor("T1"); client == nil || client == app.slack {
+		t.Errorf("Expected T1 to get a client with its own token")
+	}
+	if client := app.slackFor("T2"); client != app.slack {
+		t.Errorf("Expected other teams to use the configured token")
+	}
+
+	if err := app.uninstall("T1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := app.installs.Get("T1"); ok {
+		t.Errorf("Expected T1 to be removed after uninstalling")
+	}
+}
+
+func TestOAuthStateExpires(t *testing.T) {
+	app := &SlackApp{ClientSecret: "client-secret"}
+	issued := time.Now().Add(-time.Hour)
+	nonce, state, err := app.newOAuthState(issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.verifyOAuthState(state, nonce, issued.Add(time.Minute)); err != nil {
+		t.Errorf("Expected state to be valid, got %v", err)
+	}
+	if err := app.verifyOAuthState(state, nonce, time.Now()); err != errInvalidOAuthState {
+		t.Errorf("Expected state to expire, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+
+	"app/fig1"
+)
+
+const (
+	oembedProviderName = "Figure 1"
+	oembedProviderURL  = "https://figure1.com"
+
+	// case share images are generated for link previews, which are 1200x630
+	caseImageWidth  = 1200
+	caseImageHeight = 630
+
+	// size of the rich embeds for users and collections, unless maxwidth/maxheight are smaller
+	oembedRichWidth            = 500
+	oembedUserHeight           = 200
+	oembedCollectionHeight     = 300
+	oembedCollectionItemsShown = 3
+)
+
+// oembedResponse is a `photo` or `rich` response as described at https://oembed.com
+type oembedResponse struct {
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Title        string `json:"title,omitempty"`
+	AuthorName   string `json:"author_name,omitempty"`
+	AuthorURL    string `json:"author_url,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+
+	// photo
+	URL string `json:"url,omitempty"`
+	// rich
+	HTML string `json:"html,omitempty"`
+	// both
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+}
+
+// oembedSize is the space the consumer has for the embed, 0 means there's no limit
+type oembedSize struct {
+	maxWidth  int
+	maxHeight int
+}
+
+// fit scales width x height down to fit, keeping the aspect ratio
+func (s oembedSize) fit(width, height int) (int, int) {
+	if s.maxWidth > 0 && width > s.maxWidth {
+		height = height * s.maxWidth / width
+		width = s.maxWidth
+	}
+	if s.maxHeight > 0 && height > s.maxHeight {
+		width = width * s.maxHeight / height
+		height = s.maxHeight
+	}
+	return width, height
+}
+
+// clamp limits width x height to fit, without keeping the aspect ratio (rich html can reflow)
+func (s oembedSize) clamp(width, height int) (int, int) {
+	if s.maxWidth > 0 && width > s.maxWidth {
+		width = s.maxWidth
+	}
+	if s.maxHeight > 0 && height > s.maxHeight {
+		height = s.maxHeight
+	}
+	return width, height
+}
+
+// oembedHandler is an oEmbed provider for figure 1 links, eg: `GET /oembed?url=<case link>&format=json`
+func (app *SlackApp) oembedHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		http.Error(res, "Only json is supported", http.StatusNotImplemented)
+		return
+	}
+
+	var size oembedSize
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{"maxwidth", &size.maxWidth},
+		{"maxheight", &size.maxHeight},
+	} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			http.Error(res, "Invalid "+param.name, http.StatusBadRequest)
+			return
+		}
+		*param.value = value
+	}
+
+	kind, id := parseFig1Link(query.Get("url"))
+	if kind == "" {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return
+	}
+
+	embed, err := app.oembed(req.Context(), kind, id, size)
+	switch {
+	case err == nil:
+		writeJSON(res, http.StatusOK, embed)
+	case fig1.IsNotFound(err):
+		http.Error(res, "Not found", http.StatusNotFound)
+	default:
+		loggerFrom(req.Context()).Error("Failed to get oembed content", "kind", kind, "id", id, "err", err)
+		http.Error(res, "Failed to retrieve "+kind, http.StatusBadGateway)
+	}
+}
+
+func (app *SlackApp) oembed(ctx context.Context, kind, id string, size oembedSize) (*oembedResponse, error) {
+	switch kind {
+	case contentCase:
+		data, err := app.f1.Case(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return oembedCase(data, size), nil
+	case contentUser:
+		data, err := app.f1.User(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return oembedUser(data, size), nil
+	case contentCollection:
+		data, err := app.f1.Collection(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return oembedCollection(data, size), nil
+	}
+	return nil, fmt.Errorf("unknown content type %v", kind)
+}
+
+func newOembedResponse(embedType, title string) *oembedResponse {
+	return &oembedResponse{
+		Type:         embedType,
+		Version:      "1.0",
+		Title:        title,
+		ProviderName: oembedProviderName,
+		ProviderURL:  oembedProviderURL,
+	}
+}
+
+func oembedCase(data *fig1.Case, size oembedSize) *oembedResponse {
+	embed := newOembedResponse("photo", truncateString(data.Caption))
+	embed.AuthorName = data.Author.Username
+	embed.AuthorURL = userLinkGen(data.Author.Username)
+	embed.URL = caseLinkGen("image", data.ID)
+	embed.Width, embed.Height = size.fit(caseImageWidth, caseImageHeight)
+	return embed
+}
+
+func oembedUser(data *fig1.User, size oembedSize) *oembedResponse {
+	embed := newOembedResponse("rich", data.Username)
+	embed.AuthorName = data.Username
+	embed.AuthorURL = userLinkGen(data.Username)
+	embed.Width, embed.Height = size.clamp(oembedRichWidth, oembedUserHeight)
+
+	details := ""
+	if data.FullName != "" {
+		details += "<p><strong>" + html.EscapeString(data.FullName) + "</strong></p>"
+	}
+	details += "<p>" + html.EscapeString(data.Category+", "+data.Specialty) + "</p>"
+	if loc := userLocation(data); loc != "" {
+		details += "<p>" + html.EscapeString(loc) + "</p>"
+	}
+	if stats := userStats(data); stats != "" {
+		details += "<p><small>" + html.EscapeString(stats) + "</small></p>"
+	}
+	embed.HTML = oembedCard(embed.Width, userLinkGen(data.Username), data.Username, details)
+	return embed
+}
+
+func oembedCollection(data *fig1.Collection, size oembedSize) *oembedResponse {
+	embed := newOembedResponse("rich", data.Title)
+	if len(data.Embedded.Authors) > 0 {
+		author := data.Embedded.Authors[0]
+		embed.AuthorName = author.Username
+		embed.AuthorURL = userLinkGen(author.Username)
+	}
+	embed.Width, embed.Height = size.clamp(oembedRichWidth, oembedCollectionHeight)
+
+	details := "<p>" + html.EscapeString(truncateString(data.Description)) + "</p>"
+	items := data.Embedded.Items
+	if len(items) > oembedCollectionItemsShown {
+		items = items[:oembedCollectionItemsShown]
+	}
+	if len(items) > 0 {
+		details += "<ul>"
+		for _, item := range items {
+			details += fmt.Sprintf(`<li><a href="%v">%v</a></li>`, html.EscapeString(caseLinkGen("case", item.ID)), html.EscapeString(truncateString(item.Caption)))
+		}
+		details += "</ul>"
+	}
+	details += "<p><small>" + html.EscapeString(collectionSize(data)) + "</small></p>"
+
+	embed.HTML = oembedCard(embed.Width, collectionLinkGen(data.ID), data.Title, details)
+	if len(data.Embedded.Items) > 0 {
+		embed.ThumbnailURL = genCollectionItemImageLink(data.Embedded.Items[0].Links.Image.Href, data.Embedded.Items[0].ID)
+		embed.ThumbnailWidth, embed.ThumbnailHeight = size.fit(caseImageWidth, caseImageHeight)
+	}
+	return embed
+}
+
+// oembedCard is the html for rich embeds, a plain blockquote that consumers can style
+func oembedCard(width int, link, title, details string) string {
+	return fmt.Sprintf(`<blockquote class="figure1-embed" style="max-width: %vpx"><p><a href="%v">%v</a></p>%v<p><small>via %v</small></p></blockquote>`,
+		width, html.EscapeString(link), html.EscapeString(title), details, oembedProviderName)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"app/fig1"
+)
+
+func TestOembedHandler(t *testing.T) {
+	fake := fig1.NewFake()
+	fake.Cases["59076d6324d11b594b2dff1d"] = &fig1.Case{ID: "59076d6324d11b594b2dff1d", Caption: "Chest x-ray"}
+	fake.Users["ccovic"] = &fig1.User{Username: "ccovic", FullName: "<b>Chris</b>", Category: "Physician", Specialty: "Cardiology"}
+	app := &SlackApp{f1: fake}
+
+	tests := []struct {
+		link   string
+		params string
+		status int
+		embed  oembedResponse
+	}{
+		{"https://app.figure1.com/rd/image?imageid=59076d6324d11b594b2dff1d", "", 200, oembedResponse{Type: "photo", Width: 1200, Height: 630}},
+		{"https://app.figure1.com/rd/image?imageid=59076d6324d11b594b2dff1d", "&maxwidth=600", 200, oembedResponse{Type: "photo", Width: 600, Height: 315}},
+		{"https://app.figure1.com/rd/image?imageid=59076d6324d11b594b2dff1d", "&maxwidth=600&maxheight=100", 200, oembedResponse{Type: "photo", Width: 190, Height: 100}},
+		{"https://app.figure1.com/user/ccovic", "&maxheight=150", 200, oembedResponse{Type: "rich", Width: 500, Height: 150}},
+		{"https://app.figure1.com/rd/image?imageid=59076d6324d11b594b2dff1d", "&format=xml", 501, oembedResponse{}},
+		{"https://app.figure1.com/rd/image?imageid=59076d6324d11b594b2dff1d", "&maxwidth=wide", 400, oembedResponse{}},
+		{"https://example.com/rd/image?imageid=59076d6324d11b594b2dff1d", "", 404, oembedResponse{}},
+		{"https://app.figure1.com/rd/collections?id=5907549889c89eef5b1b3511", "", 404, oembedResponse{}},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/oembed?url="+url.QueryEscape(test.link)+test.params, nil)
+		res := httptest.NewRecorder()
+		app.oembedHandler(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("Expected status %v for %v%v, got %v", test.status, test.link, test.params, res.Code)
+			continue
+		}
+		if res.Code != http.StatusOK {
+			continue
+		}
+
+		var embed oembedResponse
+		if err := json.NewDecoder(res.Body).Decode(&embed); err != nil {
+			t.Errorf("Expected json for %v, got %v", test.link, err)
+			continue
+		}
+		if embed.Version != "1.0" || embed.Type != test.embed.Type || embed.Width != test.embed.Width || embed.Height != test.embed.Height {
+			t.Errorf("Expected %v %vx%v embed for %v%v, got %+v", test.embed.Type, test.embed.Width, test.embed.Height, test.link, test.params, embed)
+		}
+		if embed.Type == "photo" && embed.URL == "" {
+			t.Errorf("Expected photo embed to have a url, got %+v", embed)
+		}
+		if embed.Type == "rich" && (embed.HTML == "" || strings.Contains(embed.HTML, "<b>")) {
+			t.Errorf("Expected rich embed to have escaped html, got %v", embed.HTML)
+		}
+	}
+}
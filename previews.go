@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// previewTTL is how long a preview can wait to be posted, ephemeral messages
+// are gone once slack reloads anyway
+const previewTTL = time.Hour
+
+const previewExpiredMessage = "This preview has expired, please run the command again"
+
+// pendingPreview is the message shown in an ephemeral preview, as it will be posted to the channel
+type pendingPreview struct {
+	ref     previewRef
+	content *SlackResponse
+	expires time.Time
+}
+
+// previewStore keeps previews until they're posted or cancelled, so exactly what
+// the requester saw is posted. It's only kept in memory, and its zero value is ready to use.
+type previewStore struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]*pendingPreview
+}
+
+func (s *previewStore) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// add keeps a preview, returning the key for its post and cancel buttons
+func (s *previewStore) add(ref previewRef, content *SlackResponse) (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(data)
+	s.put(key, &pendingPreview{ref: ref, content: content, expires: s.clock().Add(previewTTL)})
+	return key, nil
+}
+
+// put keeps a preview under key, eg: to put back one that couldn't be posted
+func (s *previewStore) put(key string, preview *pendingPreview) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	if s.pending == nil {
+		s.pending = map[string]*pendingPreview{}
+	}
+	for k, pending := range s.pending {
+		if !now.Before(pending.expires) {
+			delete(s.pending, k)
+		}
+	}
+	s.pending[key] = preview
+}
+
+// take removes and returns a preview that hasn't expired, if it's ownerID's. Only one
+// of several clicks on its post button gets it.
+func (s *previewStore) take(key, ownerID string) (*pendingPreview, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	preview, ok := s.pending[key]
+	if !ok || !s.clock().Before(preview.expires) || preview.ref.OwnerID != ownerID {
+		return nil, false
+	}
+	delete(s.pending, key)
+	return preview, true
+}
+
+func (s *previewStore) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, key)
+}
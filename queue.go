@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"app/logging"
+)
+
+const (
+	defaultWorkers    = 4
+	defaultQueueDepth = 100
+
+	// slack only accepts posts to a `response_url` for 30 minutes
+	responseURLValidity = 30 * time.Minute
+)
+
+// job is background work, usually replying to a slash command via its `response_url`
+type job struct {
+	name     string
+	deadline time.Time
+	log      *logging.Logger
+	run      func(ctx context.Context)
+}
+
+// newJob creates a job for the request in ctx, it keeps logging with the request's id
+func newJob(ctx context.Context, name string, run func(ctx context.Context)) *job {
+	return &job{
+		name:     name,
+		deadline: time.Now().Add(responseURLValidity),
+		log:      loggerFrom(ctx),
+		run:      run,
+	}
+}
+
+// jobQueue runs jobs on a fixed number of workers. Once the queue is full new
+// jobs are rejected, so callers can tell the user to try again.
+type jobQueue struct {
+	jobs chan *job
+	wg   sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newJobQueue(workers, depth int) *jobQueue {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if depth <= 0 {
+		depth = defaultQueueDepth
+	}
+
+	q := &jobQueue{jobs: make(chan *job, depth)}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// enqueue returns false if the queue is full or shutting down
+func (q *jobQueue) enqueue(j *job) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+
+	select {
+	case q.jobs <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+// depth is the number of jobs waiting for a worker
+func (q *jobQueue) depth() int {
+	return len(q.jobs)
+}
+
+func (q *jobQueue) capacity() int {
+	return cap(q.jobs)
+}
+
+// shutdown stops accepting jobs and waits for queued and in-flight ones to
+// finish, or until ctx is done
+func (q *jobQueue) shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *jobQueue) work() {
+	defer q.wg.Done()
+	for j := range q.jobs {
+		q.runJob(j)
+	}
+}
+
+func (q *jobQueue) runJob(j *job) {
+	// the response_url won't work anymore, so there's no point doing anything
+	if time.Now().After(j.deadline) {
+		j.log.Warn("Dropping expired job", "job", j.name)
+		jobsTotal.Inc(j.name, "expired")
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(logging.NewContext(context.Background(), j.log), j.deadline)
+	defer cancel()
+
+	// one bad job shouldn't take a worker down with it
+	defer func() {
+		if r := recover(); r != nil {
+			j.log.Error("Job panicked", "job", j.name, "panic", r, "stack", string(debug.Stack()))
+			jobsTotal.Inc(j.name, "panicked")
+		}
+	}()
+
+	j.run(ctx)
+	jobsTotal.Inc(j.name, "done")
+}
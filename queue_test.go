@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"app/logging"
+)
+
+func TestJobQueueBackpressure(t *testing.T) {
+	q := newJobQueue(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	// the only worker is busy, and the queue has room for one more
+	q.enqueue(newJob(context.Background(), "blocking", func(ctx context.Context) {
+		close(started)
+		<-release
+	}))
+	<-started
+	if !q.enqueue(newJob(context.Background(), "queued", func(ctx context.Context) {})) {
+		t.Errorf("Expected job to be queued")
+	}
+	if q.enqueue(newJob(context.Background(), "rejected", func(ctx context.Context) {})) {
+		t.Errorf("Expected job to be rejected when the queue is full")
+	}
+	close(release)
+	q.shutdown(context.Background())
+}
+
+func TestJobQueueShutdownDrains(t *testing.T) {
+	q := newJobQueue(2, 10)
+
+	var mu sync.Mutex
+	ran := 0
+	for i := 0; i < 5; i++ {
+		q.enqueue(newJob(context.Background(), "job", func(ctx context.Context) {
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			ran++
+			mu.Unlock()
+		}))
+	}
+
+	if err := q.shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected shutdown to drain, got %v", err)
+	}
+	if ran != 5 {
+		t.Errorf("Expected all 5 jobs to finish before shutdown returned, got %v", ran)
+	}
+	if q.enqueue(newJob(context.Background(), "late", func(ctx context.Context) {})) {
+		t.Errorf("Expected jobs to be rejected after shutdown")
+	}
+}
+
+func TestJobDeadline(t *testing.T) {
+	q := newJobQueue(1, 1)
+	defer q.shutdown(context.Background())
+
+	deadlines := make(chan time.Time, 1)
+	requestIDs := make(chan string, 1)
+	reqCtx := logging.NewContext(context.Background(), logger.With("request_id", "abc123"))
+	j := newJob(reqCtx, "job", func(ctx context.Context) {
+		deadline, _ := ctx.Deadline()
+		deadlines <- deadline
+		requestIDs <- logging.RequestID(ctx)
+	})
+	q.enqueue(j)
+
+	if deadline := <-deadlines; !deadline.Equal(j.deadline) {
+		t.Errorf("Expected job context to expire with the response_url (%v), got %v", j.deadline, deadline)
+	}
+	if id := <-requestIDs; id != "abc123" {
+		t.Errorf("Expected job to keep the request id, got %q", id)
+	}
+
+	expired := newJob(context.Background(), "expired", func(ctx context.Context) {
+		t.Errorf("Expected expired job not to run")
+	})
+	expired.deadline = time.Now().Add(-time.Second)
+	q.enqueue(expired)
+}
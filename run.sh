@@ -10,7 +10,12 @@ function build {
 
 function run {
 	echo "Starting up figure 1 slackbot service!"
-	docker service create -p ${PORT}:${PORT} --name ${SERVICE_NAME} ${IMAGE_NAME}
+	# the image doesn't include a config, mount it if there is one
+	MOUNT=""
+	if [ -f conf.json ]; then
+		MOUNT="--mount type=bind,source=$(pwd)/conf.json,target=/app/conf.json,readonly"
+	fi
+	docker service create -p ${PORT}:${PORT} ${MOUNT} --name ${SERVICE_NAME} ${IMAGE_NAME}
 }
 
 if [ $# -eq 0 ]; then
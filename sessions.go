@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"app/fig1"
+	"app/installs"
+)
+
+const (
+	// figure1LoginPath is the page `/fig1 login` links to
+	figure1LoginPath = "/figure1/login"
+	// loginLinkTTL is how long a `/fig1 login` link works for
+	loginLinkTTL = 10 * time.Minute
+	// loginCookie ties a login link to the first browser that opens it
+	loginCookie = "fig1_login"
+)
+
+var errInvalidLoginState = errors.New("invalid login link")
+
+// userCachePrefix keeps a user's content apart in the cache, since they might
+// be able to see things nobody else can
+func userCachePrefix(teamID, userID string) string {
+	return tenantCachePrefix(teamID) + "user:" + userID + "/"
+}
+
+// userFigure1 is the figure 1 client for a user's own session, or nil if they haven't logged in
+func (app *SlackApp) userFigure1(teamID, userID string) fig1.Client {
+	if app.sessions == nil || userID == "" {
+		return nil
+	}
+	session, ok := app.sessions.Get(teamID, userID)
+	if !ok {
+		return nil
+	}
+
+	return app.userClients.get(session, func() fig1.Client {
+		// sessions can't log in again, so the only login is the one that finds it has expired
+		conf := app.figure1Config()
+		conf.Token = session.Token
+		conf.OnLogin = func(err error) {
+			if fig1.IsSessionExpired(err) {
+				app.expireSession(session)
+			}
+		}
+		cached := newCachedClient(fig1.New(conf), app.cache, app.ttls)
+		cached.prefix = userCachePrefix(teamID, userID)
+		return cached
+	})
+}
+
+// sessionClient is the client for one session, it's replaced if the user logs in again
+type sessionClient struct {
+	token  string
+	client fig1.Client
+}
+
+// sessionClients keeps one client per session, instead of one per lookup.
+// Its zero value is ready to use.
+type sessionClients struct {
+	mu      sync.Mutex
+	clients map[string]sessionClient
+}
+
+func sessionKey(teamID, userID string) string {
+	return teamID + "/" + userID
+}
+
+// get returns the session's client, creating it the first time
+func (c *sessionClients) get(session installs.Session, create func() fig1.Client) fig1.Client {
+	key := sessionKey(session.TeamID, session.UserID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.clients[key]; ok && existing.token == session.Token {
+		return existing.client
+	}
+	if c.clients == nil {
+		c.clients = map[string]sessionClient{}
+	}
+	client := create()
+	c.clients[key] = sessionClient{session.Token, client}
+	return client
+}
+
+// drop forgets a user's client, once they've logged out or their session has expired
+func (c *sessionClients) drop(teamID, userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clients, sessionKey(teamID, userID))
+}
+
+// expireSession forgets a session figure 1 rejected, unless the user has logged in again since
+func (app *SlackApp) expireSession(session installs.Session) {
+	current, ok := app.sessions.Get(session.TeamID, session.UserID)
+	if !ok || current.Token != session.Token {
+		return
+	}
+	log := logger.With("team", session.TeamID, "user", session.UserID)
+	if _, err := app.sessions.Delete(session.TeamID, session.UserID); err != nil {
+		log.Error("Failed to remove expired figure 1 session", "err", err)
+		return
+	}
+	app.userClients.drop(session.TeamID, session.UserID)
+	log.Info("Figure 1 session expired")
+}
+
+// linkFigure1User logs a slack user in to figure 1 and keeps the session, but not their password
+func (app *SlackApp) linkFigure1User(ctx context.Context, teamID, userID, email, password string) (installs.Session, error) {
+	conf := app.figure1Config()
+	conf.Email = email
+	conf.Password = password
+	token, err := fig1.New(conf).Token(ctx)
+	if err != nil {
+		return installs.Session{}, err
+	}
+
+	session := installs.Session{TeamID: teamID, UserID: userID, Email: email, LinkedAt: time.Now(), Token: token}
+	if err := app.sessions.Save(session); err != nil {
+		return installs.Session{}, err
+	}
+	app.userClients.drop(teamID, userID)
+	return session, nil
+}
+
+func (app *SlackApp) replyLogin(body *slashCommandRequestBody) *SlackResponse {
+	if app.sessions == nil || app.PublicURL == "" {
+		return &SlackResponse{
+			ResponseType: "ephemeral",
+			Text:         "Logging in to Figure 1 isn't enabled for this app, lookups use its shared account.",
+		}
+	}
+
+	state := app.newLoginState(loginLink{TeamID: body.TeamID, UserID: body.UserID, Username: body.Username, Workspace: body.TeamDomain}, time.Now())
+	link := app.PublicURL + figure1LoginPath + "?" + url.Values{"state": {state}}.Encode()
+	text := fmt.Sprintf("<%v|Log in to Figure 1> to look up content as yourself, the link works for %v minutes.", link, int(loginLinkTTL.Minutes()))
+	if session, ok := app.sessions.Get(body.TeamID, body.UserID); ok {
+		text += fmt.Sprintf(" You're logged in as %v now, logging in again replaces it.", session.Email)
+	}
+	return &SlackResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+	}
+}
+
+func (app *SlackApp) replyLogout(body *slashCommandRequestBody) *SlackResponse {
+	text := "You aren't logged in to Figure 1."
+	if app.sessions != nil {
+		removed, err := app.sessions.Delete(body.TeamID, body.UserID)
+		switch {
+		case err != nil:
+			logger.Error("Failed to remove figure 1 session", "team", body.TeamID, "user", body.UserID, "err", err)
+			text = "Failed to log out of Figure 1, please try again"
+		case removed:
+			app.userClients.drop(body.TeamID, body.UserID)
+			text = "Logged out of Figure 1, lookups will use the app's shared account again."
+		}
+	}
+	return &SlackResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+	}
+}
+
+func (app *SlackApp) replyWhoami(body *slashCommandRequestBody) *SlackResponse {
+	text := fmt.Sprintf("You aren't logged in to Figure 1, lookups use the app's shared account. Use `%v login` to use your own.", fig1Command)
+	if app.sessions != nil {
+		if session, ok := app.sessions.Get(body.TeamID, body.UserID); ok {
+			text = fmt.Sprintf("You're logged in to Figure 1 as %v, since %v.", session.Email, session.LinkedAt.Format("Jan 2, 2006"))
+		}
+	}
+	return &SlackResponse{
+		ResponseType: "ephemeral",
+		Text:         text,
+	}
+}
+
+// loginPage is the form a `/fig1 login` link opens
+type loginPage struct {
+	State     string
+	Link      loginLink
+	Email     string
+	Error     string
+	Expired   bool
+	Elsewhere bool
+	Done      bool
+}
+
+var loginPageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in to Figure 1</title>
+</head>
+<body>
+<h1>Log in to Figure 1</h1>
+{{if .Expired}}
+<p>This login link has expired, use <code>/fig1 login</code> in Slack to get a new one.</p>
+{{else if .Elsewhere}}
+<p>This login link was already opened in another browser, use <code>/fig1 login</code> in Slack to get a new one.</p>
+{{else if .Done}}
+<p>You're logged in as {{.Email}}, Figure 1 content you look up in Slack will now use your account. You can close this window.</p>
+{{else}}
+{{with .Link}}
+<p>This links your Figure 1 account to the Slack user <strong>{{if .Username}}@{{.Username}} ({{.UserID}}){{else}}{{.UserID}}{{end}}</strong>
+in the <strong>{{if .Workspace}}{{.Workspace}} ({{.TeamID}}){{else}}{{.TeamID}}{{end}}</strong> workspace.
+If that isn't you, close this window.</p>
+{{end}}
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post">
+<input type="hidden" name="state" value="{{.State}}">
+<p><label>Email <input type="email" name="email" value="{{.Email}}" required autofocus></label></p>
+<p><label>Password <input type="password" name="password" required></label></p>
+<p><button type="submit">Log in</button></p>
+</form>
+<p>Your password is only used to log in, it isn't saved.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// figure1LoginHandler is the page a `/fig1 login` link opens, which links the
+// slack user in the link to the figure 1 account they log in with
+func (app *SlackApp) figure1LoginHandler(res http.ResponseWriter, req *http.Request) {
+	if app.sessions == nil || app.loginLinks == nil {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		http.Error(res, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log := loggerFrom(req.Context())
+	state := req.FormValue("state")
+	link, err := app.verifyLoginState(state, time.Now())
+	if err != nil {
+		log.Warn("Rejected figure 1 login with an invalid state")
+		renderLoginPage(res, http.StatusBadRequest, loginPage{Expired: true})
+		return
+	}
+	log = log.With("team", link.TeamID, "user", link.UserID)
+	if !app.loginBrowser(res, req, state) {
+		log.Warn("Rejected figure 1 login from another browser")
+		renderLoginPage(res, http.StatusForbidden, loginPage{Elsewhere: true})
+		return
+	}
+	if req.Method == http.MethodGet {
+		renderLoginPage(res, http.StatusOK, loginPage{State: state, Link: link})
+		return
+	}
+
+	page := loginPage{State: state, Link: link, Email: strings.TrimSpace(req.PostFormValue("email"))}
+	password := req.PostFormValue("password")
+	if page.Email == "" || password == "" {
+		page.Error = "Enter your Figure 1 email and password"
+		renderLoginPage(res, http.StatusBadRequest, page)
+		return
+	}
+
+	if _, err := app.linkFigure1User(req.Context(), link.TeamID, link.UserID, page.Email, password); err != nil {
+		if fig1.IsUnauthorized(err) {
+			userLoginsTotal.Inc("unauthorized")
+			page.Error = "That email and password didn't work, please try again"
+			renderLoginPage(res, http.StatusUnauthorized, page)
+			return
+		}
+		userLoginsTotal.Inc("error")
+		log.Error("Failed to log in to figure 1", "err", err)
+		page.Error = "Failed to log in to Figure 1, please try again later"
+		renderLoginPage(res, http.StatusBadGateway, page)
+		return
+	}
+
+	userLoginsTotal.Inc("ok")
+	log.Info("Linked figure 1 account")
+	http.SetCookie(res, app.newLoginCookie("", -1))
+	renderLoginPage(res, http.StatusOK, loginPage{Email: page.Email, Done: true})
+}
+
+func renderLoginPage(res http.ResponseWriter, status int, page loginPage) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the form has a live state, so it shouldn't be cached or framed
+	res.Header().Set("Cache-Control", "no-store")
+	res.Header().Set("X-Frame-Options", "DENY")
+	res.WriteHeader(status)
+	if err := loginPageTemplate.Execute(res, page); err != nil {
+		logger.Error("Failed to render login page", "err", err)
+	}
+}
+
+// loginBrowser reports whether a login link is being used by the browser that first opened
+// it. The first GET claims the link and sets a cookie, so a forwarded link is useless.
+func (app *SlackApp) loginBrowser(res http.ResponseWriter, req *http.Request, state string) bool {
+	if cookie, err := req.Cookie(loginCookie); err == nil && app.verifyLoginNonce(state, cookie.Value) {
+		return true
+	}
+	if req.Method != http.MethodGet || !app.loginLinks.claim(state) {
+		return false
+	}
+
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		loggerFrom(req.Context()).Error("Failed to create login nonce", "err", err)
+		app.loginLinks.release(state)
+		return false
+	}
+	nonce := hex.EncodeToString(data)
+	http.SetCookie(res, app.newLoginCookie(nonce+"."+signState(app.loginSigningKey(), state+"."+nonce), int(loginLinkTTL.Seconds())))
+	return true
+}
+
+// newLoginCookie is only sent back to the login page, a negative maxAge removes it
+func (app *SlackApp) newLoginCookie(value string, maxAge int) *http.Cookie {
+	path := figure1LoginPath
+	if u, err := url.Parse(app.PublicURL); err == nil {
+		path = u.Path + figure1LoginPath
+	}
+	return &http.Cookie{
+		Name:     loginCookie,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.PublicURL, "https://"),
+	}
+}
+
+// verifyLoginNonce checks a login cookie of `nonce.signature` was set for the state
+func (app *SlackApp) verifyLoginNonce(state, value string) bool {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 || parts[0] == "" {
+		return false
+	}
+	expected := signState(app.loginSigningKey(), state+"."+parts[0])
+	return hmac.Equal([]byte(parts[1]), []byte(expected))
+}
+
+// loginSigningKey signs login links and cookies. It's derived from `encryption_key`,
+// so the key that encrypts tokens and passwords isn't used for anything else.
+func (app *SlackApp) loginSigningKey() string {
+	mac := hmac.New(sha256.New, []byte(app.EncryptionKey))
+	mac.Write([]byte("fig1-login-state"))
+	return string(mac.Sum(nil))
+}
+
+// loginLink is who a `/fig1 login` link is for, the names are only shown on the page
+type loginLink struct {
+	TeamID    string `json:"team"`
+	UserID    string `json:"user"`
+	Username  string `json:"username,omitempty"`
+	Workspace string `json:"workspace,omitempty"`
+	Issued    int64  `json:"issued"`
+}
+
+// newLoginState is `payload.signature`, with the link as base64 json, so the login
+// page knows who it's for without any server side storage
+func (app *SlackApp) newLoginState(link loginLink, now time.Time) string {
+	link.Issued = now.Unix()
+	data, _ := json.Marshal(link)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signState(app.loginSigningKey(), payload)
+}
+
+func (app *SlackApp) verifyLoginState(state string, now time.Time) (loginLink, error) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 2 {
+		return loginLink{}, errInvalidLoginState
+	}
+	expected := signState(app.loginSigningKey(), parts[0])
+	if !hmac.Equal([]byte(parts[1]), []byte(expected)) {
+		return loginLink{}, errInvalidLoginState
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return loginLink{}, errInvalidLoginState
+	}
+	var link loginLink
+	if err := json.Unmarshal(data, &link); err != nil || link.TeamID == "" || link.UserID == "" {
+		return loginLink{}, errInvalidLoginState
+	}
+	if now.Sub(time.Unix(link.Issued, 0)) > loginLinkTTL {
+		return loginLink{}, errInvalidLoginState
+	}
+	return link, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"app/cache"
+	"app/fig1"
+	"app/installs"
+)
+
+func TestFigure1Login(t *testing.T) {
+	server := fakeFigure1Accounts()
+	defer server.Close()
+
+	cipher, _ := installs.NewCipher(make([]byte, installs.KeySize))
+	app := &SlackApp{
+		PublicURL:     "https://example.com/fig1-slack",
+		EncryptionKey: "key",
+		Fig1AppURL:    server.URL,
+		Fig1Timeout:   duration(defaultFig1Timeout),
+		cache:         cache.NewMemory(100),
+		sessions:      installs.NewSessionStore(cipher),
+		loginLinks:    newDedupStore(loginLinkTTL, maxDedupKeys),
+	}
+	app.f1 = newCachedClient(app.newFigure1Client("default@example.com", "secret", func(error) {}), app.cache, nil)
+	cmd := &slashCommandRequestBody{TeamID: "T1", TeamDomain: "acme", UserID: "U1", Username: "bob"}
+
+	// `/fig1 login` links to the login page with a signed state
+	reply := app.replyLogin(cmd)
+	match := regexp.MustCompile(`<(https://example.com/fig1-slack/figure1/login\?state=[^|]+)\|`).FindStringSubmatch(reply.Text)
+	if match == nil {
+		t.Fatalf("Expected a login link, got %q", reply.Text)
+	}
+	link, _ := url.Parse(match[1])
+	state := link.Query().Get("state")
+
+	// the login link's browser keeps the cookie it's given, any other browser has none
+	var cookies []*http.Cookie
+	login := func(method, state string, form url.Values, browser bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, figure1LoginPath+"?state="+url.QueryEscape(state), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if browser {
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+		}
+		res := httptest.NewRecorder()
+		app.figure1LoginHandler(res, req)
+		if browser && res.Code == 200 && method == "GET" {
+			cookies = res.Result().Cookies()
+		}
+		return res
+	}
+
+	// a state signed for someone else can't be reused with another payload
+	forged := app.newLoginState(loginLink{TeamID: "T1", UserID: "U2"}, time.Now())
+	forged = forged[:strings.Index(forged, ".")] + state[strings.Index(state, "."):]
+
+	tests := []struct {
+		name    string
+		method  string
+		state   string
+		form    url.Values
+		browser bool
+		status  int
+	}{
+		{"post before opening", "POST", state, url.Values{"email": {"u1@example.com"}, "password": {"secret"}}, false, 403},
+		{"form", "GET", state, nil, true, 200},
+		{"form in another browser", "GET", state, nil, false, 403},
+		{"post from another browser", "POST", state, url.Values{"email": {"u1@example.com"}, "password": {"secret"}}, false, 403},
+		{"forged state", "GET", forged, nil, true, 400},
+		{"missing password", "POST", state, url.Values{"email": {"u1@example.com"}}, true, 400},
+		{"wrong password", "POST", state, url.Values{"email": {"u1@example.com"}, "password": {"wrong"}}, true, 401},
+		{"logged in", "POST", state, url.Values{"email": {"u1@example.com"}, "password": {"secret"}}, true, 200},
+	}
+	for _, test := range tests {
+		res := login(test.method, test.state, test.form, test.browser)
+		if res.Code != test.status {
+			t.Errorf("Expected %v to be %v, got %v: %s", test.name, test.status, res.Code, res.Body)
+		}
+		if test.name == "form" && (!strings.Contains(res.Body.String(), "@bob (U1)") || !strings.Contains(res.Body.String(), "acme (T1)")) {
+			t.Errorf("Expected the form to show who it logs in, got %s", res.Body)
+		}
+		if test.name == "form" && (len(cookies) != 1 || cookies[0].Path != "/fig1-slack"+figure1LoginPath || !cookies[0].HttpOnly || !cookies[0].Secure) {
+			t.Errorf("Expected a login cookie for the page, got %+v", cookies)
+		}
+	}
+
+	caption := func(userID string) string {
+		body := &slashCommandRequestBody{TeamID: "T1", UserID: userID}
+		data, err := app.contentClient(body).Case(context.Background(), "abc")
+		if err != nil {
+			t.Fatalf("Expected case for %v, got %v", userID, err)
+		}
+		// what a user's own account can see is only shown to them until they post it
+		if body.Preview != (data.Caption != "seen by default@example.com") {
+			t.Errorf("Expected %v's lookup to be previewed only with their own account, got %v", userID, body.Preview)
+		}
+		return data.Caption
+	}
+	if got := caption("U1"); got != "seen by u1@example.com" {
+		t.Errorf("Expected U1 to use their own account, got %q", got)
+	}
+	if app.userFigure1("T1", "U1") != app.userFigure1("T1", "U1") {
+		t.Errorf("Expected one client for U1's session")
+	}
+
+	// unfurls are seen by the whole channel, so they never use a user's session
+	content, err := app.fetchContent(context.Background(), "T1", contentCase, "abc", "")
+	if err != nil || !strings.Contains(renderJSON(t, content), "seen by default@example.com") {
+		t.Errorf("Expected unfurls to use the shared account, got %v (err: %v)", renderJSON(t, content), err)
+	}
+	if got := caption("U2"); got != "seen by default@example.com" {
+		t.Errorf("Expected other users to use the shared account, got %q", got)
+	}
+	if session, _ := app.sessions.Get("T1", "U1"); session.Token != "Bearer u1@example.com" {
+		t.Errorf("Expected U1's session to be saved, got %+v", session)
+	}
+	if text := app.replyWhoami(cmd).Text; !strings.Contains(text, "u1@example.com") {
+		t.Errorf("Expected whoami to show U1's account, got %q", text)
+	}
+
+	if text := app.replyLogout(cmd).Text; !strings.HasPrefix(text, "Logged out") {
+		t.Errorf("Expected U1 to be logged out, got %q", text)
+	}
+	if got := caption("U1"); got != "seen by default@example.com" {
+		t.Errorf("Expected U1 to go back to the shared account, got %q", got)
+	}
+	if len(app.userClients.clients) != 0 {
+		t.Errorf("Expected U1's client to be dropped, got %v", app.userClients.clients)
+	}
+}
+
+func TestFigure1SessionExpires(t *testing.T) {
+	cipher, _ := installs.NewCipher(make([]byte, installs.KeySize))
+	app := &SlackApp{
+		Fig1AppURL:  "http://127.0.0.1:0",
+		Fig1Timeout: duration(defaultFig1Timeout),
+		cache:       cache.NewMemory(100),
+		sessions:    installs.NewSessionStore(cipher),
+	}
+
+	// a jwt that expired long ago, so it's never sent
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1}`))
+	app.sessions.Save(installs.Session{TeamID: "T1", UserID: "U1", Email: "u1@example.com", Token: "Bearer header." + claims + ".sig"})
+
+	_, err := app.userFigure1("T1", "U1").Case(context.Background(), "abc")
+	if !fig1.IsSessionExpired(err) {
+		t.Fatalf("Expected the session to have expired, got %v", err)
+	}
+	if !strings.Contains(fig1ErrorResponse(contentCase, err), "/fig1 login") {
+		t.Errorf("Expected to be asked to log in again, got %q", fig1ErrorResponse(contentCase, err))
+	}
+	if _, ok := app.sessions.Get("T1", "U1"); ok {
+		t.Errorf("Expected the expired session to be removed")
+	}
+}
+
+func TestLoginStateExpires(t *testing.T) {
+	app := &SlackApp{EncryptionKey: "key"}
+	issued := time.Now().Add(-time.Hour)
+	state := app.newLoginState(loginLink{TeamID: "T1", UserID: "U1", Username: "bob"}, issued)
+	if link, err := app.verifyLoginState(state, issued.Add(time.Minute)); err != nil || link.TeamID != "T1" || link.UserID != "U1" || link.Username != "bob" {
+		t.Errorf("Expected state for T1/U1, got %+v (err: %v)", link, err)
+	}
+	if payload := state[:strings.Index(state, ".")]; strings.HasSuffix(state, signState(app.EncryptionKey, payload)) {
+		t.Errorf("Expected the state not to be signed with the encryption key itself")
+	}
+	if _, err := app.verifyLoginState(state, time.Now()); err != errInvalidLoginState {
+		t.Errorf("Expected state to expire, got %v", err)
+	}
+}
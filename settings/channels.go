@@ -0,0 +1,65 @@
+// Package settings keeps what people change about the app from slack, eg: with
+// `/fig1 preview`. Nothing in it is secret, so it isn't encrypted.
+package settings
+
+import (
+	"app/jsonfile"
+)
+
+// ChannelsFile is the name of the file Channels keeps in its data dir
+const ChannelsFile = "channels.json"
+
+// Channel is the options a channel has changed
+type Channel struct {
+	Preview bool `json:"preview"`
+}
+
+// storedChannel is a Channel as it's saved to the file
+type storedChannel struct {
+	ChannelID string `json:"channel_id"`
+	Channel
+}
+
+// Channels is the channels that have changed their options, by channel id
+type Channels struct {
+	table *jsonfile.Table
+}
+
+// NewChannels keeps channel settings only until the app restarts
+func NewChannels() *Channels {
+	return &Channels{table: jsonfile.NewTable(func(channelID string, value interface{}) (interface{}, error) {
+		return storedChannel{ChannelID: channelID, Channel: value.(Channel)}, nil
+	})}
+}
+
+// OpenChannels reads the channel settings saved in dir
+func OpenChannels(dir string) (*Channels, error) {
+	s := NewChannels()
+	var stored []storedChannel
+	if err := s.table.Open(dir, ChannelsFile, &stored); err != nil {
+		return nil, err
+	}
+	for _, channel := range stored {
+		s.table.Load(channel.ChannelID, channel.Channel)
+	}
+	return s, nil
+}
+
+// Get returns a channel's settings, if they've been changed
+func (s *Channels) Get(channelID string) (Channel, bool) {
+	channel, ok := s.table.Get(channelID)
+	if !ok {
+		return Channel{}, false
+	}
+	return channel.(Channel), true
+}
+
+// Count is how many channels have changed their settings
+func (s *Channels) Count() int {
+	return s.table.Len()
+}
+
+// Save adds or replaces a channel's settings
+func (s *Channels) Save(channelID string, channel Channel) error {
+	return s.table.Set(channelID, channel)
+}
@@ -0,0 +1,36 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestChannels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "channels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenChannels(dir)
+	if err != nil {
+		t.Fatalf("Expected empty store, got %v", err)
+	}
+	if _, ok := store.Get("C1"); ok {
+		t.Errorf("Expected C1 not to have settings yet")
+	}
+	store.Save("C1", Channel{Preview: true})
+	store.Save("C2", Channel{Preview: true})
+	store.Save("C2", Channel{Preview: false})
+
+	reopened, err := OpenChannels(dir)
+	if err != nil {
+		t.Fatalf("Expected store to reopen, got %v", err)
+	}
+	c1, _ := reopened.Get("C1")
+	c2, ok := reopened.Get("C2")
+	if !c1.Preview || !ok || c2.Preview || reopened.Count() != 2 {
+		t.Errorf("Expected C1 on and C2 off after reopening, got %+v %+v (count: %v)", c1, c2, reopened.Count())
+	}
+}
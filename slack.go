@@ -1,15 +1,16 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"app/fig1"
 )
 
-const slackPostMsgLink = "https://slack.com/api/chat.postMessage"
 const (
 	verifiedBadgeLink       = "http://i.imgur.com/9eyI61P.jpg"
 	topContributorBadgeLink = "http://i.imgur.com/oYpmgwF.jpg"
@@ -22,9 +23,12 @@ const (
 
 // SlackResponse is the wrapper for responding to slash commands
 type SlackResponse struct {
-	ResponseType string        `json:"response_type"`
-	Text         string        `json:"text,omitempty"`
-	Attachments  []*Attachment `json:"attachments"`
+	ResponseType    string        `json:"response_type,omitempty"`
+	ReplaceOriginal bool          `json:"replace_original,omitempty"`
+	DeleteOriginal  bool          `json:"delete_original,omitempty"`
+	Text            string        `json:"text,omitempty"`
+	Attachments     []*Attachment `json:"attachments,omitempty"`
+	Blocks          []Block       `json:"blocks,omitempty"`
 }
 
 // Attachment is individual item when posting a message to slack
@@ -51,35 +55,82 @@ type Field struct {
 	Short bool   `json:"short,omitempty"`
 }
 
-func respondToSlashCommand(link string, attachments []*Attachment) {
-	body := &SlackResponse{
-		ResponseType: "in_channel",
-		Attachments:  attachments,
-	}
+// respondToSlashCommand posts content to the channel a slash command was used in, then
+// removes the loading message. An ephemeral message can't be replaced with one everyone
+// can see, so it has to be deleted. There's no point posting an error to the same
+// response_url if that fails.
+func (app *SlackApp) respondToSlashCommand(ctx context.Context, cmd *slashCommandRequestBody, body *SlackResponse) {
+	body.ResponseType = "in_channel"
 
-	// marshal body
-	reqBody := new(bytes.Buffer)
-	if err := json.NewEncoder(reqBody).Encode(body); err != nil {
-		msg := "Failed to encode slack JSON"
-		(&slackError{msg, msg, err}).handleError(link)
+	if err := app.respond(ctx, cmd.target(), body); err != nil {
+		loggerFrom(ctx).Error("Failed to respond to slash command", "err", err)
 		return
 	}
+	if err := postToResponseURL(ctx, cmd.ResponseURL, &SlackResponse{DeleteOriginal: true}); err != nil {
+		loggerFrom(ctx).Warn("Failed to remove loading message", "err", err)
+	}
+}
+
+const (
+	formatLegacy = "legacy"
+	formatBlocks = "blocks"
+)
 
-	// create request
-	req, err := http.NewRequest("POST", link, reqBody)
-	req.Header.Set("Content-Type", "application/json")
+// renderer turns figure 1 content into a slack message in one of the supported formats
+type renderer interface {
+	renderCase(data *fig1.Case, opUser string) *SlackResponse
+	renderUser(data *fig1.User, opUser string) *SlackResponse
+	renderCollection(data *fig1.Collection, opUser string) *SlackResponse
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		msg := "Failed to connect to slack api"
-		(&slackError{msg, msg, err}).handleError(link)
-		return
+// legacyRenderer uses the deprecated attachments
+type legacyRenderer struct{}
+
+func (legacyRenderer) renderCase(data *fig1.Case, opUser string) *SlackResponse {
+	return &SlackResponse{Attachments: generateCaseContent(data, opUser)}
+}
+func (legacyRenderer) renderUser(data *fig1.User, opUser string) *SlackResponse {
+	return &SlackResponse{Attachments: generateUserContent(data, opUser)}
+}
+func (legacyRenderer) renderCollection(data *fig1.Collection, opUser string) *SlackResponse {
+	return &SlackResponse{Attachments: generateCollectionContent(data, opUser)}
+}
+
+// blocksRenderer uses block kit, `Text` is only shown in notifications
+type blocksRenderer struct{}
+
+func (blocksRenderer) renderCase(data *fig1.Case, opUser string) *SlackResponse {
+	return &SlackResponse{
+		Text:   "FIGURE 1 CASE: " + escapeMrkdwn(truncateString(data.Caption)),
+		Blocks: generateCaseBlocks(data, opUser),
+	}
+}
+func (blocksRenderer) renderUser(data *fig1.User, opUser string) *SlackResponse {
+	return &SlackResponse{
+		Text:   "FIGURE 1 USER: " + escapeMrkdwn(data.Username),
+		Blocks: generateUserBlocks(data, opUser),
+	}
+}
+func (blocksRenderer) renderCollection(data *fig1.Collection, opUser string) *SlackResponse {
+	return &SlackResponse{
+		Text:   "FIGURE 1 COLLECTION: " + escapeMrkdwn(data.Title),
+		Blocks: generateCollectionBlocks(data, opUser),
 	}
-	defer resp.Body.Close()
 }
 
-func generateCaseContent(data *f1Case, opUser string) []*Attachment {
+// renderer picks the message format configured for a workspace
+func (app *SlackApp) renderer(teamID string) renderer {
+	format := app.MessageFormat
+	if ws, ok := app.Workspaces[teamID]; ok && ws.MessageFormat != "" {
+		format = ws.MessageFormat
+	}
+	if format == formatBlocks {
+		return blocksRenderer{}
+	}
+	return legacyRenderer{}
+}
+
+func generateCaseContent(data *fig1.Case, opUser string) []*Attachment {
 	attachments := []*Attachment{}
 
 	// author
@@ -103,27 +154,24 @@ func generateCaseContent(data *f1Case, opUser string) []*Attachment {
 	caption := truncateString(data.Caption)
 	authorSection.Fallback = "FIGURE 1 CASE: " + caption
 	caseInfoSection.Text = caption
-	caseInfoSection.Footer = strings.Join([]string{
-		data.ImageViews,
-		strconv.Itoa(data.VoteCount) + " stars",
-		strconv.Itoa(data.CommentCount) + " comments",
-		strconv.Itoa(data.Followers) + " followers",
-	}, ", ")
+	caseInfoSection.Footer = withCachedNote(caseStats(data), data.CachedAt)
 	attachments = append(attachments, &caseInfoSection)
 
 	// share links
 	shareSection := Attachment{
-		Title:  "Share case link",
-		Text:   caseLinkGen("case", data.ID),
-		Color:  colorLightBlue,
-		Footer: fmt.Sprintf("posted by @%v", opUser),
+		Title: "Share case link",
+		Text:  caseLinkGen("case", data.ID),
+		Color: colorLightBlue,
+	}
+	if opUser != "" {
+		shareSection.Footer = fmt.Sprintf("posted by @%v", opUser)
 	}
 	attachments = append(attachments, &shareSection)
 
 	return attachments
 }
 
-func generateUserContent(data *f1User, opUser string) []*Attachment {
+func generateUserContent(data *fig1.User, opUser string) []*Attachment {
 	attachments := []*Attachment{}
 
 	// main section
@@ -159,16 +207,7 @@ func generateUserContent(data *f1User, opUser string) []*Attachment {
 	}
 
 	// institution + location
-	loc := ""
-	if data.ProfileCountry != "" {
-		loc = data.ProfileCountry
-	} else {
-		loc = data.Country
-	}
-	if data.Institution != "" {
-		loc = data.Institution + ", " + loc
-	}
-	if loc != "" {
+	if loc := userLocation(data); loc != "" {
 		institutionCountryField := &Field{
 			Title: "Institution/Country",
 			Value: loc,
@@ -178,49 +217,37 @@ func generateUserContent(data *f1User, opUser string) []*Attachment {
 	}
 
 	// stats
-	var stats []string
-	for key, count := range map[string]int{
-		"comments":  data.CommentsCount,
-		"favorites": data.FavoritesCount,
-		"followers": data.FollowersCount,
-		"following": data.FollowingCount,
-		"uploads":   data.UploadsCount,
-	} {
-		if count != 0 {
-			stats = append(stats, strconv.Itoa(count)+" "+key)
-		}
-	}
-	extraContentSection.Footer = strings.Join(stats, ", ")
+	extraContentSection.Footer = withCachedNote(userStats(data), data.CachedAt)
 	attachments = append(attachments, &extraContentSection)
 
 	// share links
 	shareSection := Attachment{
-		Title:  "Share profile link",
-		Text:   userLinkGen(data.Username),
-		Color:  colorLightBlue,
-		Footer: fmt.Sprintf("posted by @%v", opUser),
+		Title: "Share profile link",
+		Text:  userLinkGen(data.Username),
+		Color: colorLightBlue,
+	}
+	if opUser != "" {
+		shareSection.Footer = fmt.Sprintf("posted by @%v", opUser)
 	}
 	attachments = append(attachments, &shareSection)
 
 	return attachments
 }
 
-func generateCollectionContent(data *f1Collection, opUser string) []*Attachment {
+func generateCollectionContent(data *fig1.Collection, opUser string) []*Attachment {
 	attachments := []*Attachment{}
 
 	// collection info
-	author := data.Embedded.Authors[0]
 	mainSection := Attachment{
-		AuthorLink: userLinkGen(author.Username),
-		AuthorName: author.Username,
-		Title:      data.Title,
-		Fallback:   "FIGURE 1 COLLECTION: " + data.Title,
+		Title:    data.Title,
+		Fallback: "FIGURE 1 COLLECTION: " + data.Title,
 	}
-	if data.Size == 1 {
-		mainSection.Footer = "1 case"
-	} else {
-		mainSection.Footer = fmt.Sprintf("%v cases", data.Size)
+	if len(data.Embedded.Authors) > 0 {
+		author := data.Embedded.Authors[0]
+		mainSection.AuthorLink = userLinkGen(author.Username)
+		mainSection.AuthorName = author.Username
 	}
+	mainSection.Footer = withCachedNote(collectionSize(data), data.CachedAt)
 	mainSection.Text = truncateString(data.Description)
 	attachments = append(attachments, &mainSection)
 
@@ -236,42 +263,108 @@ func generateCollectionContent(data *f1Collection, opUser string) []*Attachment
 			Text:     truncateString(item.Caption),
 			ThumbURL: genCollectionItemImageLink(item.Links.Image.Href, item.ID),
 		}
-		attachment.Footer = strings.Join([]string{
-			strconv.Itoa(item.VoteCount) + " stars",
-			strconv.Itoa(item.CommentCount) + " comments",
-			strconv.Itoa(item.Followers) + " followers",
-		}, ", ")
+		attachment.Footer = itemStats(item.VoteCount, item.CommentCount, item.Followers)
 		attachments = append(attachments, &attachment)
 	}
 
 	// share links
 	shareSection := Attachment{
-		Title:  "Share collection link",
-		Text:   collectionLinkGen(data.ID),
-		Color:  colorLightBlue,
-		Footer: fmt.Sprintf("posted by @%v", opUser),
+		Title: "Share collection link",
+		Text:  collectionLinkGen(data.ID),
+		Color: colorLightBlue,
+	}
+	if opUser != "" {
+		shareSection.Footer = fmt.Sprintf("posted by @%v", opUser)
 	}
 	attachments = append(attachments, &shareSection)
 
 	return attachments
 }
 
+func caseStats(data *fig1.Case) string {
+	return data.ImageViews + ", " + itemStats(data.VoteCount, data.CommentCount, data.Followers)
+}
+
+func itemStats(votes, comments, followers int) string {
+	return strings.Join([]string{
+		strconv.Itoa(votes) + " stars",
+		strconv.Itoa(comments) + " comments",
+		strconv.Itoa(followers) + " followers",
+	}, ", ")
+}
+
+func userStats(data *fig1.User) string {
+	var stats []string
+	for _, stat := range []struct {
+		key   string
+		count int
+	}{
+		{"comments", data.CommentsCount},
+		{"favorites", data.FavoritesCount},
+		{"followers", data.FollowersCount},
+		{"following", data.FollowingCount},
+		{"uploads", data.UploadsCount},
+	} {
+		if stat.count != 0 {
+			stats = append(stats, strconv.Itoa(stat.count)+" "+stat.key)
+		}
+	}
+	return strings.Join(stats, ", ")
+}
+
+func userLocation(data *fig1.User) string {
+	loc := ""
+	if data.ProfileCountry != "" {
+		loc = data.ProfileCountry
+	} else {
+		loc = data.Country
+	}
+	if data.Institution != "" {
+		loc = data.Institution + ", " + loc
+	}
+	return loc
+}
+
+func collectionSize(data *fig1.Collection) string {
+	if data.Size == 1 {
+		return "1 case"
+	}
+	return fmt.Sprintf("%v cases", data.Size)
+}
+
+// withCachedNote adds a note to a footer if the content is an old copy from the cache
+func withCachedNote(footer string, cachedAt time.Time) string {
+	if cachedAt.IsZero() {
+		return footer
+	}
+
+	note := "cached 1 minute ago"
+	if minutes := int(time.Since(cachedAt).Minutes()); minutes != 1 {
+		note = fmt.Sprintf("cached %v minutes ago", minutes)
+	}
+	if footer == "" {
+		return note
+	}
+	return footer + " | " + note
+}
+
+// link generators escape what they're given, ids and usernames come from figure 1 as is
 func caseLinkGen(linkType, val string) string {
 	switch linkType {
 	case "case":
-		return "https://app.figure1.com/rd/image?imageid=" + val
+		return "https://app.figure1.com/rd/image?imageid=" + url.QueryEscape(val)
 	case "image":
-		return "https://s3.amazonaws.com/static.figure1.com/img/share/" + val
+		return "https://s3.amazonaws.com/static.figure1.com/img/share/" + url.PathEscape(val)
 	}
 	return ""
 }
 
 func userLinkGen(username string) string {
-	return "https://app.figure1.com/rd/publicprofile?username=" + username
+	return "https://app.figure1.com/rd/publicprofile?username=" + url.QueryEscape(username)
 }
 
 func collectionLinkGen(id string) string {
-	return "https://app.figure1.com/rd/collections?id=" + id
+	return "https://app.figure1.com/rd/collections?id=" + url.QueryEscape(id)
 }
 
 func genCollectionItemImageLink(link, collectionID string) string {
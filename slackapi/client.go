@@ -0,0 +1,195 @@
+// Package slackapi is a small client for the slack web api methods the app uses
+package slackapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"app/logging"
+)
+
+// DefaultBaseURL is slack's web api
+const DefaultBaseURL = "https://slack.com/api"
+
+// Config is everything needed to create a Client
+type Config struct {
+	// Token is the bot token (`xoxb-...`) sent with every call
+	Token string
+
+	// BaseURL is where methods are called, without a trailing slash, eg: a local fake in tests
+	BaseURL string
+
+	// HTTPClient is shared between all calls, defaults to a client with a 10 second timeout
+	HTTPClient *http.Client
+
+	// OnCall, if set, is called with the result of every call
+	OnCall func(ctx context.Context, method string, err error)
+}
+
+// Client calls slack web api methods
+type Client struct {
+	token   string
+	baseURL string
+	http    *http.Client
+	onCall  func(ctx context.Context, method string, err error)
+}
+
+// New creates a client
+func New(conf Config) *Client {
+	c := &Client{
+		token:   conf.Token,
+		baseURL: strings.TrimSuffix(conf.BaseURL, "/"),
+		http:    conf.HTTPClient,
+		onCall:  conf.OnCall,
+	}
+	if c.baseURL == "" {
+		c.baseURL = DefaultBaseURL
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 10 * time.Second}
+	}
+	return c
+}
+
+// Response is the part of every method's response that says whether it worked
+type Response struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// Message is the body of `chat.postMessage` and `chat.postEphemeral`, the
+// attachments and blocks are passed through as is
+type Message struct {
+	Channel     string      `json:"channel"`
+	User        string      `json:"user,omitempty"` // only for ephemeral messages
+	Text        string      `json:"text,omitempty"`
+	Attachments interface{} `json:"attachments,omitempty"`
+	Blocks      interface{} `json:"blocks,omitempty"`
+}
+
+// PostMessageResponse is returned by `chat.postMessage`
+type PostMessageResponse struct {
+	Response
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// PostMessage posts a message to a channel the bot is in, returning the message's timestamp
+func (c *Client) PostMessage(ctx context.Context, msg *Message) (string, error) {
+	var resp PostMessageResponse
+	if err := c.Call(ctx, "chat.postMessage", msg, &resp); err != nil {
+		return "", err
+	}
+	return resp.TS, nil
+}
+
+// PostEphemeral posts a message only msg.User can see
+func (c *Client) PostEphemeral(ctx context.Context, msg *Message) error {
+	return c.Call(ctx, "chat.postEphemeral", msg, nil)
+}
+
+// ConnectionsOpenResponse is returned by `apps.connections.open`
+type ConnectionsOpenResponse struct {
+	Response
+	URL string `json:"url"`
+}
+
+// OpenConnection returns a websocket url for socket mode, the client's token has
+// to be an app-level token (`xapp-...`) with the `connections:write` scope
+func (c *Client) OpenConnection(ctx context.Context) (string, error) {
+	var resp ConnectionsOpenResponse
+	if err := c.Call(ctx, "apps.connections.open", struct{}{}, &resp); err != nil {
+		return "", err
+	}
+	return resp.URL, nil
+}
+
+// WithToken is a copy of the client that calls methods with a different token,
+// eg: the bot token of another workspace
+func (c *Client) WithToken(token string) *Client {
+	clone := *c
+	clone.token = token
+	return &clone
+}
+
+// Call posts body as json to a method, and decodes the response into out if
+// it's not nil. A response with `ok: false` is returned as an *Error.
+func (c *Client) Call(ctx context.Context, method string, body, out interface{}) (err error) {
+	if c.onCall != nil {
+		defer func() { c.onCall(ctx, method, err) }()
+	}
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.baseURL+"/"+method, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	return c.do(ctx, method, req, out)
+}
+
+// callForm is Call for methods that only accept form encoded bodies
+func (c *Client) callForm(ctx context.Context, method string, form url.Values, user, password string, out interface{}) (err error) {
+	if c.onCall != nil {
+		defer func() { c.onCall(ctx, method, err) }()
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/"+method, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(user, password)
+	return c.do(ctx, method, req, out)
+}
+
+func (c *Client) do(ctx context.Context, method string, req *http.Request, out interface{}) error {
+	req = req.WithContext(ctx)
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &Error{Method: method, Err: err}
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &Error{Method: method, Status: resp.StatusCode, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &Error{
+			Method: method,
+			Status: resp.StatusCode,
+			Err:    fmt.Errorf("unexpected response: %.200s", data),
+		}
+	}
+
+	var result Response
+	if err := json.Unmarshal(data, &result); err != nil {
+		return &Error{Method: method, Status: resp.StatusCode, Err: fmt.Errorf("failed to decode response: %v", err)}
+	}
+	if !result.OK {
+		return &Error{Method: method, Status: resp.StatusCode, Code: result.Error}
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return &Error{Method: method, Status: resp.StatusCode, Err: fmt.Errorf("failed to decode response: %v", err)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package slackapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeSlack answers chat.postMessage, only for channel C1 and the token "xoxb-test"
+func fakeSlack(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat.postMessage", func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer xoxb-test" {
+			json.NewEncoder(res).Encode(Response{Error: InvalidAuth})
+			return
+		}
+		var msg Message
+		if err := json.NewDecoder(req.Body).Decode(&msg); err != nil {
+			t.Errorf("Expected a json message, got %v", err)
+		}
+		if msg.Channel != "C1" {
+			json.NewEncoder(res).Encode(Response{Error: ChannelNotFound})
+			return
+		}
+		json.NewEncoder(res).Encode(PostMessageResponse{Response: Response{OK: true}, Channel: "C1", TS: "1520000000.000100"})
+	})
+	mux.HandleFunc("/chat.postEphemeral", func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestPostMessage(t *testing.T) {
+	server := fakeSlack(t)
+	defer server.Close()
+
+	var calls []string
+	client := New(Config{
+		Token:   "xoxb-test",
+		BaseURL: server.URL + "/",
+		OnCall: func(ctx context.Context, method string, err error) {
+			calls = append(calls, method+" "+ErrorCode(err))
+		},
+	})
+
+	ts, err := client.PostMessage(context.Background(), &Message{Channel: "C1", Text: "hi"})
+	if err != nil || ts != "1520000000.000100" {
+		t.Errorf("Expected message to be posted, got %q (err: %v)", ts, err)
+	}
+
+	_, err = client.PostMessage(context.Background(), &Message{Channel: "C2", Text: "hi"})
+	if ErrorCode(err) != ChannelNotFound {
+		t.Errorf("Expected %v, got %v", ChannelNotFound, err)
+	}
+
+	err = client.PostEphemeral(context.Background(), &Message{Channel: "C1", User: "U1", Text: "hi"})
+	if e, ok := err.(*Error); !ok || e.Status != 503 || e.Code != "" {
+		t.Errorf("Expected a 503 error, got %#v", err)
+	}
+
+	unauthed := New(Config{Token: "xoxb-other", BaseURL: server.URL})
+	if _, err := unauthed.PostMessage(context.Background(), &Message{Channel: "C1"}); !IsAuthError(err) {
+		t.Errorf("Expected an auth error, got %v", err)
+	}
+
+	expected := []string{"chat.postMessage ", "chat.postMessage channel_not_found", "chat.postEphemeral "}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("Expected call %v to be %q, got %q", i, expected[i], calls[i])
+		}
+	}
+}
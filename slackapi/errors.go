@@ -0,0 +1,53 @@
+package slackapi
+
+import (
+	"fmt"
+)
+
+// error codes slack returns that the app handles
+const (
+	ChannelNotFound = "channel_not_found"
+	NotInChannel    = "not_in_channel"
+	InvalidAuth     = "invalid_auth"
+	TokenRevoked    = "token_revoked"
+	RateLimited     = "ratelimited"
+)
+
+// Error is returned for every failed call
+type Error struct {
+	Method string
+	// Code is the `error` from an `ok: false` response, eg: "channel_not_found"
+	Code string
+	// Status is the http status, or 0 if there was no response
+	Status int
+	// Err is set if the call failed before slack could say what was wrong
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := "slack " + e.Method
+	if e.Status != 0 && e.Status != 200 {
+		msg += fmt.Sprintf(" (status: %v)", e.Status)
+	}
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// ErrorCode returns the slack error code of err, or "" if slack didn't return one
+func ErrorCode(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return ""
+}
+
+// IsAuthError reports whether slack rejected the token
+func IsAuthError(err error) bool {
+	code := ErrorCode(err)
+	return code == InvalidAuth || code == TokenRevoked || code == "not_authed" || code == "account_inactive"
+}
@@ -0,0 +1,49 @@
+package slackapi
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// OAuthV2Response is returned by `oauth.v2.access` once a workspace has installed the app
+type OAuthV2Response struct {
+	Response
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Scope       string `json:"scope"`
+	BotUserID   string `json:"bot_user_id"`
+	AppID       string `json:"app_id"`
+	Team        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"team"`
+	Enterprise *struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"enterprise"`
+	AuthedUser struct {
+		ID string `json:"id"`
+	} `json:"authed_user"`
+}
+
+// AuthorizeURL is where users are sent to install the app, it's next to the web api,
+// eg: https://slack.com/oauth/v2/authorize for https://slack.com/api
+func (c *Client) AuthorizeURL(params url.Values) string {
+	return strings.TrimSuffix(c.baseURL, "/api") + "/oauth/v2/authorize?" + params.Encode()
+}
+
+// OAuthV2Access exchanges the code slack redirects back with for a bot token.
+// redirectURI must match the one sent to AuthorizeURL, if there was one.
+func (c *Client) OAuthV2Access(ctx context.Context, clientID, clientSecret, code, redirectURI string) (*OAuthV2Response, error) {
+	form := url.Values{"code": {code}}
+	if redirectURI != "" {
+		form.Set("redirect_uri", redirectURI)
+	}
+
+	var resp OAuthV2Response
+	if err := c.callForm(ctx, "oauth.v2.access", form, clientID, clientSecret, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
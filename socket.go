@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"app/socketmode"
+)
+
+// slackRoute is a route slack sends requests to, over http or socket mode
+type slackRoute struct {
+	path    string
+	handler http.HandlerFunc
+}
+
+// slackRoutes are registered behind verifySlackRequest for http, socket mode
+// envelopes skip that since only slack can send them over the connection
+func (app *SlackApp) slackRoutes() []slackRoute {
+	return []slackRoute{
+		// `/case`, `/user` and `/collection` are aliases for the matching `/fig1` subcommand
+		{fig1Command, app.slashCommandHandler},
+		{"/case", app.slashCommandHandler},
+		{"/user", app.slashCommandHandler},
+		{"/collection", app.slashCommandHandler},
+		{"/events", app.eventsHandler},
+		{"/interactions", app.interactionsHandler},
+	}
+}
+
+// startSocketMode receives slack requests over a websocket. The returned func stops
+// it, once the envelopes that are being handled have been acknowledged.
+func (app *SlackApp) startSocketMode() func() {
+	client := socketmode.New(socketmode.Config{
+		API:     app.slack.WithToken(app.AppToken),
+		Handler: app.handleEnvelope,
+		Logger:  logger.With("transport", "socket_mode"),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// handleEnvelope passes an envelope to the same handler as the matching http route,
+// so it's logged, counted and answered the same way. The handler's response is the
+// acknowledgement's payload, and 5xx responses aren't acknowledged so slack retries
+// them, just like it would over http.
+func (app *SlackApp) handleEnvelope(ctx context.Context, env *socketmode.Envelope) (interface{}, error) {
+	req, err := envelopeRequest(env)
+	if err != nil {
+		// slack would only send it again
+		logger.Warn("Ignored socket mode envelope", "type", env.Type, "err", err)
+		return nil, nil
+	}
+
+	var handler http.Handler
+	for _, route := range app.slackRoutes() {
+		if route.path == req.URL.Path {
+			handler = withRequestID(route.path, instrument(route.path, route.handler))
+			break
+		}
+	}
+	if handler == nil {
+		logger.Warn("Ignored socket mode envelope", "type", env.Type, "path", req.URL.Path)
+		return nil, nil
+	}
+
+	res := newEnvelopeResponse()
+	handler.ServeHTTP(res, req.WithContext(ctx))
+	if res.status >= 500 {
+		return nil, fmt.Errorf("%v responded with %v: %v", req.URL.Path, res.status, strings.TrimSpace(res.body.String()))
+	}
+	if env.AcceptsResponsePayload && res.body.Len() > 0 && strings.HasPrefix(res.header.Get("Content-Type"), "application/json") {
+		return json.RawMessage(res.body.Bytes()), nil
+	}
+	return nil, nil
+}
+
+// envelopeRequest is the http request slack would have sent instead of an envelope
+func envelopeRequest(env *socketmode.Envelope) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	switch env.Type {
+	case socketmode.TypeSlashCommands:
+		// the same fields as the form slack posts, as a json object
+		var fields map[string]interface{}
+		if err := json.Unmarshal(env.Payload, &fields); err != nil {
+			return nil, err
+		}
+		form := url.Values{}
+		for key, value := range fields {
+			if text, ok := value.(string); ok {
+				form.Set(key, text)
+			}
+		}
+		if req, err = http.NewRequest("POST", form.Get("command"), strings.NewReader(form.Encode())); err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	case socketmode.TypeEventsAPI:
+		if req, err = http.NewRequest("POST", "/events", bytes.NewReader(env.Payload)); err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	case socketmode.TypeInteractive:
+		form := url.Values{"payload": {string(env.Payload)}}
+		if req, err = http.NewRequest("POST", "/interactions", strings.NewReader(form.Encode())); err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	default:
+		return nil, fmt.Errorf("unknown envelope type %q", env.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if env.RetryAttempt > 0 {
+		req.Header.Set("X-Slack-Retry-Num", strconv.Itoa(env.RetryAttempt))
+		req.Header.Set("X-Slack-Retry-Reason", env.RetryReason)
+	}
+	return req, nil
+}
+
+// envelopeResponse keeps what a handler responded with, to acknowledge the envelope with
+type envelopeResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newEnvelopeResponse() *envelopeResponse {
+	return &envelopeResponse{header: http.Header{}}
+}
+
+func (r *envelopeResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *envelopeResponse) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+func (r *envelopeResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"app/fig1"
+	"app/socketmode"
+)
+
+func TestHandleEnvelope(t *testing.T) {
+	app := &SlackApp{f1: fig1.NewFake(), queue: newJobQueue(1, 1)}
+
+	handle := func(env string) (string, error) {
+		var envelope socketmode.Envelope
+		if err := json.Unmarshal([]byte(env), &envelope); err != nil {
+			t.Fatal(err)
+		}
+		payload, err := app.handleEnvelope(context.Background(), &envelope)
+		if payload == nil {
+			return "", err
+		}
+		data, _ := json.Marshal(payload)
+		return string(data), err
+	}
+
+	// slash commands are answered in the acknowledgement
+	payload, err := handle(`{"envelope_id":"e1","type":"slash_commands","accepts_response_payload":true,"payload":{"command":"/fig1","text":"help","team_id":"T1","channel_id":"C1","user_id":"U1","user_name":"bob"}}`)
+	if err != nil || !strings.Contains(payload, "Figure 1 commands") || !strings.Contains(payload, `"response_type":"ephemeral"`) {
+		t.Errorf("Expected the help message as the payload, got %v (err: %v)", payload, err)
+	}
+
+	tests := []struct {
+		name string
+		env  string
+	}{
+		{"unknown command", `{"envelope_id":"e2","type":"slash_commands","payload":{"command":"/other"}}`},
+		{"ignored event", `{"envelope_id":"e3","type":"events_api","payload":{"type":"event_callback","event":{"type":"message"}}}`},
+		{"ignored interaction", `{"envelope_id":"e4","type":"interactive","payload":{"type":"view_closed"}}`},
+		{"unknown type", `{"envelope_id":"e5","type":"something_new","payload":{}}`},
+	}
+	for _, test := range tests {
+		if payload, err := handle(test.env); payload != "" || err != nil {
+			t.Errorf("Expected %v to be acknowledged without a payload, got %v (err: %v)", test.name, payload, err)
+		}
+	}
+
+	// when the app is too busy it isn't acknowledged, so slack sends it again
+	app.queue.shutdown(context.Background())
+	_, err = handle(`{"envelope_id":"e6","type":"events_api","payload":{"type":"event_callback","event":{"type":"link_shared"}}}`)
+	if err == nil {
+		t.Errorf("Expected a busy app not to acknowledge events")
+	}
+}
+
+func TestEnvelopeRequestRetries(t *testing.T) {
+	req, err := envelopeRequest(&socketmode.Envelope{Type: socketmode.TypeEventsAPI, Payload: json.RawMessage(`{}`), RetryAttempt: 2, RetryReason: "timeout"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.Path != "/events" || req.Header.Get("X-Slack-Retry-Num") != "2" || req.Header.Get("X-Slack-Retry-Reason") != "timeout" {
+		t.Errorf("Expected a retried events request, got %v %v", req.URL.Path, req.Header)
+	}
+}
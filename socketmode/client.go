@@ -0,0 +1,216 @@
+// Package socketmode receives slack slash commands, events and interactions
+// over a websocket, so the app doesn't need a public url
+package socketmode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"app/logging"
+	"app/slackapi"
+)
+
+// envelope types, see https://api.slack.com/apis/connections/socket
+const (
+	TypeHello         = "hello"
+	TypeDisconnect    = "disconnect"
+	TypeSlashCommands = "slash_commands"
+	TypeEventsAPI     = "events_api"
+	TypeInteractive   = "interactive"
+)
+
+// DefaultPingTimeout is how long a connection can go without hearing from slack
+const DefaultPingTimeout = 30 * time.Second
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+var errDisconnect = errors.New("slack asked to reconnect")
+
+// Envelope is everything slack sends over the websocket. Anything with an
+// EnvelopeID has to be acknowledged, or slack sends it again.
+type Envelope struct {
+	EnvelopeID             string          `json:"envelope_id"`
+	Type                   string          `json:"type"`
+	Payload                json.RawMessage `json:"payload"`
+	AcceptsResponsePayload bool            `json:"accepts_response_payload"`
+	RetryAttempt           int             `json:"retry_attempt"`
+	RetryReason            string          `json:"retry_reason"`
+
+	// Reason is why a disconnect was sent, eg: "refresh_requested"
+	Reason string `json:"reason"`
+}
+
+// ack acknowledges an envelope, slack shows the payload for slash commands like the
+// body of an http response
+type ack struct {
+	EnvelopeID string      `json:"envelope_id"`
+	Payload    interface{} `json:"payload,omitempty"`
+}
+
+// Handler handles an envelope, returning the payload to acknowledge it with, if any.
+// Envelopes aren't acknowledged if it returns an error, so slack sends them again.
+type Handler func(ctx context.Context, env *Envelope) (interface{}, error)
+
+// Config is everything needed to create a Client
+type Config struct {
+	// API calls `apps.connections.open`, so it has to have the app-level token
+	API *slackapi.Client
+
+	Handler Handler
+
+	// PingTimeout is how long the connection can be quiet before it's replaced,
+	// slack pings every few seconds. Defaults to DefaultPingTimeout.
+	PingTimeout time.Duration
+
+	// Logger defaults to one that discards everything
+	Logger *logging.Logger
+}
+
+// Client keeps a socket mode connection open, reconnecting whenever it drops
+type Client struct {
+	api         *slackapi.Client
+	handler     Handler
+	pingTimeout time.Duration
+	log         *logging.Logger
+
+	// backoff is how long to wait after a failed connection, it's reset by a hello
+	backoff time.Duration
+}
+
+// New creates a client, call Run to connect
+func New(conf Config) *Client {
+	c := &Client{
+		api:         conf.API,
+		handler:     conf.Handler,
+		pingTimeout: conf.PingTimeout,
+		log:         conf.Logger,
+		backoff:     minBackoff,
+	}
+	if c.pingTimeout == 0 {
+		c.pingTimeout = DefaultPingTimeout
+	}
+	if c.log == nil {
+		c.log = logging.New(ioutil.Discard, logging.Text, logging.Error)
+	}
+	return c
+}
+
+// Run receives envelopes until ctx is done, waiting for any that are still being
+// handled before it returns
+func (c *Client) Run(ctx context.Context) error {
+	for {
+		err := c.connect(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == errDisconnect {
+			continue
+		}
+
+		c.log.Warn("Socket mode connection failed", "err", err, "retry_in", c.backoff)
+		select {
+		case <-time.After(c.backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		c.backoff *= 2
+		if c.backoff > maxBackoff {
+			c.backoff = maxBackoff
+		}
+	}
+}
+
+// connect opens a new connection and reads from it until it drops, slack asks
+// for a new one, or ctx is done
+func (c *Client) connect(ctx context.Context) error {
+	openCtx, cancel := context.WithTimeout(ctx, c.pingTimeout)
+	link, err := c.api.OpenConnection(openCtx)
+	if err == nil {
+		var ws *conn
+		if ws, err = dial(openCtx, link); err == nil {
+			cancel()
+			return c.serve(ctx, ws)
+		}
+	}
+	cancel()
+	return err
+}
+
+func (c *Client) serve(ctx context.Context, ws *conn) error {
+	var handlers sync.WaitGroup
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		handlers.Wait()
+		ws.close(closeNormal, "")
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			// stops the read below, envelopes that are being handled are still acknowledged
+			ws.stopReading()
+		case <-done:
+		}
+	}()
+
+	// every frame slack sends, including its pings, shows the connection is alive
+	ws.readTimeout = c.pingTimeout
+	for {
+		data, err := ws.readMessage()
+		if err != nil {
+			return err
+		}
+
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			c.log.Warn("Ignored socket mode message", "err", err)
+			continue
+		}
+
+		switch env.Type {
+		case TypeHello:
+			c.log.Info("Socket mode connected")
+			c.backoff = minBackoff
+			continue
+		case TypeDisconnect:
+			c.log.Info("Socket mode reconnecting", "reason", env.Reason)
+			return errDisconnect
+		}
+		if env.EnvelopeID == "" {
+			continue
+		}
+
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			c.handle(ws, &env)
+		}()
+	}
+}
+
+// handle passes an envelope to the handler and acknowledges it. Like an http request,
+// handling isn't cut short when Run stops.
+func (c *Client) handle(ws *conn, env *Envelope) {
+	log := c.log.With("envelope_id", env.EnvelopeID, "type", env.Type)
+	payload, err := c.handler(context.Background(), env)
+	if err != nil {
+		log.Warn("Socket mode envelope not acknowledged", "err", err)
+		return
+	}
+
+	data, err := json.Marshal(&ack{EnvelopeID: env.EnvelopeID, Payload: payload})
+	if err != nil {
+		log.Error("Failed to encode acknowledgement", "err", err)
+		return
+	}
+	if err := ws.writeText(data); err != nil {
+		log.Warn("Failed to acknowledge envelope", "err", err)
+	}
+}
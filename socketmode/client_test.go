@@ -0,0 +1,213 @@
+package socketmode
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"app/slackapi"
+)
+
+// standIn is a local stand-in for slack's socket mode, every websocket that
+// connects to it is passed to the test on conns
+type standIn struct {
+	*httptest.Server
+	conns chan *conn
+	opens int32
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{conns: make(chan *conn, 4)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/apps.connections.open", func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer xapp-test" {
+			json.NewEncoder(res).Encode(slackapi.Response{Error: slackapi.InvalidAuth})
+			return
+		}
+		atomic.AddInt32(&s.opens, 1)
+		link := "ws" + strings.TrimPrefix(s.URL, "http") + "/link?ticket=1"
+		json.NewEncoder(res).Encode(slackapi.ConnectionsOpenResponse{Response: slackapi.Response{OK: true}, URL: link})
+	})
+	mux.HandleFunc("/link", func(res http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get("Sec-WebSocket-Key")
+		if req.Header.Get("Upgrade") != "websocket" || key == "" || req.URL.Query().Get("ticket") != "1" {
+			t.Errorf("Expected a websocket handshake, got %v", req.Header)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		nc, rw, err := res.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		nc.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"))
+		s.conns <- &conn{nc: nc, br: rw.Reader}
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *standIn) accept(t *testing.T) *conn {
+	select {
+	case ws := <-s.conns:
+		ws.nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the client to connect")
+	}
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	server := newStandIn(t)
+	defer server.Close()
+
+	handled := make(chan *Envelope, 4)
+	client := New(Config{
+		API: slackapi.New(slackapi.Config{Token: "xapp-test", BaseURL: server.URL + "/api"}),
+		Handler: func(ctx context.Context, env *Envelope) (interface{}, error) {
+			handled <- env
+			if env.Type == TypeEventsAPI {
+				return nil, errors.New("busy")
+			}
+			return map[string]string{"text": "ok"}, nil
+		},
+		PingTimeout: 5 * time.Second,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- client.Run(ctx) }()
+
+	ws := server.accept(t)
+	ws.writeText([]byte(`{"type":"hello","num_connections":1}`))
+
+	// pings are answered straight away
+	ws.write(opPing, []byte("hi"))
+	if _, opcode, payload, err := ws.readFrame(); err != nil || opcode != opPong || string(payload) != "hi" {
+		t.Errorf("Expected a pong, got %v %q (err: %v)", opcode, payload, err)
+	}
+
+	ack := func() string {
+		data, err := ws.readMessage()
+		if err != nil {
+			t.Fatalf("Expected an acknowledgement, got %v", err)
+		}
+		return string(data)
+	}
+
+	ws.writeText([]byte(`{"envelope_id":"e1","type":"slash_commands","payload":{"command":"/fig1","text":"help"},"accepts_response_payload":true}`))
+	if got := ack(); got != `{"envelope_id":"e1","payload":{"text":"ok"}}` {
+		t.Errorf("Expected e1 to be acknowledged with a payload, got %v", got)
+	}
+	if env := <-handled; string(env.Payload) != `{"command":"/fig1","text":"help"}` {
+		t.Errorf("Expected the envelope's payload to be passed on, got %s", env.Payload)
+	}
+
+	// a failed envelope isn't acknowledged, so slack sends it again
+	ws.writeText([]byte(`{"envelope_id":"e2","type":"events_api","payload":{},"retry_attempt":1,"retry_reason":"timeout"}`))
+	if env := <-handled; env.RetryAttempt != 1 || env.RetryReason != "timeout" {
+		t.Errorf("Expected retry details, got %+v", env)
+	}
+	ws.writeText([]byte(`{"envelope_id":"e3","type":"interactive","payload":{}}`))
+	if got := ack(); !strings.Contains(got, `"e3"`) {
+		t.Errorf("Expected only e3 to be acknowledged, got %v", got)
+	}
+	<-handled
+
+	// slack asks for a new connection every few hours
+	ws.writeText([]byte(`{"type":"disconnect","reason":"refresh_requested"}`))
+	ws = server.accept(t)
+	if opens := atomic.LoadInt32(&server.opens); opens != 2 {
+		t.Errorf("Expected a new connection to be opened, got %v", opens)
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != context.Canceled {
+			t.Errorf("Expected Run to stop with the context, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to stop")
+	}
+	if _, opcode, _, err := ws.readFrame(); err != nil || opcode != opClose {
+		t.Errorf("Expected the connection to be closed, got %v (err: %v)", opcode, err)
+	}
+}
+
+func TestPingsKeepConnection(t *testing.T) {
+	server := newStandIn(t)
+	defer server.Close()
+
+	client := New(Config{
+		API: slackapi.New(slackapi.Config{Token: "xapp-test", BaseURL: server.URL + "/api"}),
+		Handler: func(ctx context.Context, env *Envelope) (interface{}, error) {
+			return nil, nil
+		},
+		PingTimeout: 200 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- client.Run(ctx) }()
+
+	ws := server.accept(t)
+	ws.writeText([]byte(`{"type":"hello","num_connections":1}`))
+
+	// a quiet connection that's still being pinged is alive
+	for start := time.Now(); time.Since(start) < 600*time.Millisecond; {
+		ws.write(opPing, []byte("hi"))
+		if _, opcode, _, err := ws.readFrame(); err != nil || opcode != opPong {
+			t.Fatalf("Expected a pong, got %v (err: %v)", opcode, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if opens := atomic.LoadInt32(&server.opens); opens != 1 {
+		t.Errorf("Expected the connection to be kept, got %v opens", opens)
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != context.Canceled {
+			t.Errorf("Expected Run to stop with the context, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to stop")
+	}
+}
+
+func TestFrames(t *testing.T) {
+	server, client := netPipe()
+	defer server.nc.Close()
+	defer client.nc.Close()
+
+	// clients mask what they send, and messages can be split into fragments
+	for _, size := range []int{0, 125, 126, 70000} {
+		message := strings.Repeat("x", size)
+		go client.writeText([]byte(message))
+		if got, err := server.readMessage(); err != nil || string(got) != message {
+			t.Errorf("Expected a %v byte message, got %v (err: %v)", size, len(got), err)
+		}
+	}
+
+	go func() {
+		server.nc.Write([]byte{opText, 3, 'a', 'b', 'c'})
+		server.nc.Write([]byte{0x80 | opContinuation, 3, 'd', 'e', 'f'})
+	}()
+	if got, err := client.readMessage(); err != nil || string(got) != "abcdef" {
+		t.Errorf("Expected fragments to be joined, got %q (err: %v)", got, err)
+	}
+}
+
+// netPipe is a server and client connected to each other
+func netPipe() (*conn, *conn) {
+	a, b := net.Pipe()
+	return &conn{nc: a, br: bufio.NewReader(a)}, &conn{nc: b, br: bufio.NewReader(b), mask: true}
+}
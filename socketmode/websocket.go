@@ -0,0 +1,333 @@
+package socketmode
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocket opcodes, see RFC 6455 section 5.2
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+const (
+	// acceptGUID is appended to the handshake key to prove the server speaks websocket
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maxMessageSize is far bigger than any envelope slack sends
+	maxMessageSize = 16 << 20
+
+	closeNormal = 1000
+)
+
+var errMessageTooBig = errors.New("websocket message too big")
+
+// closeError is returned once the other side closes the connection
+type closeError struct {
+	code   int
+	reason string
+}
+
+func (e *closeError) Error() string {
+	return fmt.Sprintf("websocket closed (code: %v) %v", e.code, e.reason)
+}
+
+// conn is just enough of a websocket for socket mode: text messages, ping/pong and close.
+// Reads are only done by one goroutine, writes can come from any.
+type conn struct {
+	nc net.Conn
+	br *bufio.Reader
+	// clients mask every frame they send, servers never do
+	mask bool
+	// readTimeout is how long each frame, pings included, can take to arrive. Zero waits forever.
+	readTimeout time.Duration
+
+	mu     sync.Mutex
+	closed bool
+
+	// once reads are stopped, frames that arrive don't extend the deadline again
+	deadlineMu sync.Mutex
+	stopped    bool
+}
+
+// dial opens a websocket to a ws:// or wss:// url
+func dial(ctx context.Context, rawurl string) (*conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	switch u.Scheme {
+	case "wss":
+		if u.Port() == "" {
+			host += ":443"
+		}
+	case "ws":
+		if u.Port() == "" {
+			host += ":80"
+		}
+	default:
+		return nil, fmt.Errorf("expected a ws or wss url, got %q", u.Scheme)
+	}
+
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+	if u.Scheme == "wss" {
+		tc := tls.Client(nc, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+
+	c, err := handshake(nc, u)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// handshake upgrades an http connection to a websocket
+func handshake(nc net.Conn, u *url.URL) (*conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Host:       u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err := req.Write(nc); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(nc)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.EqualFold(res.Header.Get("Upgrade"), "websocket") ||
+		res.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("websocket handshake failed (status: %v)", res.StatusCode)
+	}
+	return &conn{nc: nc, br: br, mask: true}, nil
+}
+
+// acceptKey is what the server has to answer a handshake key with
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// readMessage returns the next text or binary message. Pings are answered,
+// and a close is answered then returned as a *closeError.
+func (c *conn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.write(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			closeErr := &closeError{code: 1005}
+			if len(payload) >= 2 {
+				closeErr.code = int(binary.BigEndian.Uint16(payload))
+				closeErr.reason = string(payload[2:])
+			}
+			c.close(closeNormal, "")
+			return nil, closeErr
+		}
+
+		if len(message)+len(payload) > maxMessageSize {
+			return nil, errMessageTooBig
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads a single frame, unmasking it if needed
+func (c *conn) readFrame() (bool, byte, []byte, error) {
+	if err := c.extendDeadline(); err != nil {
+		return false, 0, nil, err
+	}
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxMessageSize {
+		return false, 0, nil, errMessageTooBig
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(key, payload)
+	}
+	return fin, opcode, payload, nil
+}
+
+// extendDeadline gives the other side another readTimeout to send a frame
+func (c *conn) extendDeadline() error {
+	if c.readTimeout == 0 {
+		return nil
+	}
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	if c.stopped {
+		return nil
+	}
+	return c.nc.SetReadDeadline(time.Now().Add(c.readTimeout))
+}
+
+// stopReading makes the current read, and every one after it, fail
+func (c *conn) stopReading() {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.stopped = true
+	c.nc.SetReadDeadline(time.Now())
+}
+
+// write sends a whole message as a single frame
+func (c *conn) write(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode, 0}
+	switch {
+	case len(payload) < 126:
+		frame[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		frame[1] = 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame[1] = 127
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+
+	data := append([]byte(nil), payload...)
+	if c.mask {
+		var key [4]byte
+		if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+			return err
+		}
+		frame[1] |= 0x80
+		frame = append(frame, key[:]...)
+		maskBytes(key, data)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return &closeError{code: closeNormal, reason: "already closed"}
+	}
+	_, err := c.nc.Write(append(frame, data...))
+	return err
+}
+
+func (c *conn) writeText(data []byte) error {
+	return c.write(opText, data)
+}
+
+// close sends a close frame (if it hasn't already) and closes the connection
+func (c *conn) close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	c.write(opClose, payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.nc.Close()
+}
+
+func maskBytes(key [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
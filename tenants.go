@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"app/fig1"
+	"app/installs"
+)
+
+// figure1Tenant is a workspace that looks up content with its own figure 1 account,
+// with its own bearer token, login monitor and cache keys
+type figure1Tenant struct {
+	email  string
+	client fig1.Client
+	auth   *authMonitor
+}
+
+// figure1Tenants are the workspaces with their own figure 1 account, every other
+// workspace uses the `email`/`password` from the config
+type figure1Tenants struct {
+	store *installs.CredentialStore
+
+	mu    sync.RWMutex
+	teams map[string]*figure1Tenant
+}
+
+func newFigure1Tenants(store *installs.CredentialStore) *figure1Tenants {
+	return &figure1Tenants{store: store, teams: map[string]*figure1Tenant{}}
+}
+
+func (t *figure1Tenants) get(teamID string) *figure1Tenant {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.teams[teamID]
+}
+
+// put replaces a team's tenant, the old one stops retrying its login
+func (t *figure1Tenants) put(teamID string, tenant *figure1Tenant) {
+	t.mu.Lock()
+	previous := t.teams[teamID]
+	if tenant == nil {
+		delete(t.teams, teamID)
+	} else {
+		t.teams[teamID] = tenant
+	}
+	t.mu.Unlock()
+
+	if previous != nil {
+		previous.auth.stop()
+	}
+}
+
+func (t *figure1Tenants) status() map[string]authStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	status := map[string]authStatus{}
+	for teamID, tenant := range t.teams {
+		status[teamID] = tenant.auth.status()
+	}
+	return status
+}
+
+// tenantCachePrefix keeps each workspace's content apart in the cache, since
+// accounts might not be able to see the same things
+func tenantCachePrefix(teamID string) string {
+	return "team:" + teamID + "/"
+}
+
+// workspaceFigure1 is the figure 1 client for a workspace's own account, or the
+// app's shared one. It's used for anything everyone in the channel will see.
+func (app *SlackApp) workspaceFigure1(teamID string) fig1.Client {
+	if app.tenants != nil {
+		if tenant := app.tenants.get(teamID); tenant != nil {
+			return tenant.client
+		}
+	}
+	return app.f1
+}
+
+// figure1Config has the settings shared by every figure 1 client, without an account
+func (app *SlackApp) figure1Config() fig1.Config {
+	return fig1.Config{
+		AppURL:     app.Fig1AppURL,
+		APIURL:     app.Fig1APIURL,
+		HTTPClient: &http.Client{Timeout: time.Duration(app.Fig1Timeout)},
+		OnRequest:  observeFigure1Request,
+	}
+}
+
+// newFigure1Client creates a client for a figure 1 account, each one keeps its own bearer token
+func (app *SlackApp) newFigure1Client(email, password string, onLogin func(err error)) *fig1.HTTPClient {
+	conf := app.figure1Config()
+	conf.Email = email
+	conf.Password = password
+	conf.OnLogin = func(err error) {
+		figure1LoginsTotal.Inc(resultLabel(err))
+		onLogin(err)
+	}
+	return fig1.New(conf)
+}
+
+func (app *SlackApp) newFigure1Tenant(creds installs.Credentials) *figure1Tenant {
+	tenant := &figure1Tenant{email: creds.Email}
+	var client *fig1.HTTPClient
+	tenant.auth = newAuthMonitor(func(ctx context.Context) error {
+		return client.Login(ctx)
+	})
+	tenant.auth.log = logger.With("team", creds.TeamID)
+	client = app.newFigure1Client(creds.Email, creds.Password, tenant.auth.observe)
+
+	cached := newCachedClient(client, app.cache, app.ttls)
+	cached.prefix = tenantCachePrefix(creds.TeamID)
+	tenant.client = cached
+	return tenant
+}
+
+// setFigure1Credentials switches a workspace to another figure 1 account, but only if it can log in
+func (app *SlackApp) setFigure1Credentials(ctx context.Context, creds installs.Credentials) error {
+	tenant := app.newFigure1Tenant(creds)
+	if err := tenant.client.Login(ctx); err != nil {
+		tenant.auth.stop()
+		return err
+	}
+	if err := app.tenants.store.Save(creds); err != nil {
+		tenant.auth.stop()
+		return err
+	}
+	app.tenants.put(creds.TeamID, tenant)
+	return nil
+}
+
+// removeFigure1Credentials switches a workspace back to the default account
+func (app *SlackApp) removeFigure1Credentials(teamID string) (bool, error) {
+	removed, err := app.tenants.store.Delete(teamID)
+	if removed {
+		app.tenants.put(teamID, nil)
+	}
+	return removed, err
+}
+
+// loadFigure1Tenants creates a client for every workspace with saved credentials,
+// logging in to each in the background
+func (app *SlackApp) loadFigure1Tenants(store *installs.CredentialStore) {
+	app.tenants = newFigure1Tenants(store)
+	for _, creds := range store.List() {
+		tenant := app.newFigure1Tenant(creds)
+		app.tenants.put(creds.TeamID, tenant)
+		go func(client fig1.Client) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(app.Fig1Timeout))
+			defer cancel()
+			// failures are logged and retried by the tenant's auth monitor
+			client.Login(ctx)
+		}(tenant.client)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"app/cache"
+	"app/fig1"
+	"app/installs"
+)
+
+// fakeFigure1Accounts shows each account a case captioned with its email,
+// the password is always "secret"
+func fakeFigure1Accounts() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/s/auth/login", func(res http.ResponseWriter, req *http.Request) {
+		var body struct{ Email, Password string }
+		json.NewDecoder(req.Body).Decode(&body)
+		if body.Password != "secret" {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(res).Encode(map[string]string{"token": "Bearer " + body.Email})
+	})
+	mux.HandleFunc("/s/case/", func(res http.ResponseWriter, req *http.Request) {
+		id := strings.TrimPrefix(req.URL.Path, "/s/case/")
+		email := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		json.NewEncoder(res).Encode(map[string]string{"_id": id, "caption": "seen by " + email})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestFigure1Tenants(t *testing.T) {
+	server := fakeFigure1Accounts()
+	defer server.Close()
+
+	cipher, _ := installs.NewCipher(make([]byte, installs.KeySize))
+	app := &SlackApp{
+		Fig1AppURL:  server.URL,
+		Fig1Timeout: duration(defaultFig1Timeout),
+		cache:       cache.NewMemory(100),
+		tenants:     newFigure1Tenants(installs.NewCredentialStore(cipher)),
+	}
+	app.auth = newAuthMonitor(func(ctx context.Context) error { return nil })
+	app.f1 = newCachedClient(app.newFigure1Client("default@example.com", "secret", app.auth.observe), app.cache, nil)
+
+	caption := func(teamID string) string {
+		data, err := app.workspaceFigure1(teamID).Case(context.Background(), "abc")
+		if err != nil {
+			t.Fatalf("Expected case for %v, got %v", teamID, err)
+		}
+		return data.Caption
+	}
+
+	if got := caption("T1"); got != "seen by default@example.com" {
+		t.Errorf("Expected the default account before T1 has its own, got %q", got)
+	}
+
+	err := app.setFigure1Credentials(context.Background(), installs.Credentials{TeamID: "T1", Email: "t1@example.com", Password: "wrong"})
+	if !fig1.IsUnauthorized(err) || app.tenants.get("T1") != nil {
+		t.Errorf("Expected rejected credentials not to be used, got %v", err)
+	}
+
+	if err := app.setFigure1Credentials(context.Background(), installs.Credentials{TeamID: "T1", Email: "t1@example.com", Password: "secret"}); err != nil {
+		t.Fatalf("Expected T1's credentials to be set, got %v", err)
+	}
+	if got := caption("T1"); got != "seen by t1@example.com" {
+		t.Errorf("Expected T1 to use its own account, not the cached default copy, got %q", got)
+	}
+	if got := caption("T2"); got != "seen by default@example.com" {
+		t.Errorf("Expected other teams to use the default account, got %q", got)
+	}
+	if _, ok := app.cache.Get(tenantCachePrefix("T1") + cacheKey(contentCase, "abc")); !ok {
+		t.Errorf("Expected T1's content to be cached under its own key")
+	}
+
+	// rotating replaces the client
+	if err := app.setFigure1Credentials(context.Background(), installs.Credentials{TeamID: "T1", Email: "t1-new@example.com", Password: "secret"}); err != nil {
+		t.Fatalf("Expected T1's credentials to be rotated, got %v", err)
+	}
+	if creds, _ := app.tenants.store.Get("T1"); creds.Email != "t1-new@example.com" || app.tenants.get("T1").email != "t1-new@example.com" {
+		t.Errorf("Expected T1's new credentials to be saved, got %+v", creds)
+	}
+
+	if removed, err := app.removeFigure1Credentials("T1"); !removed || err != nil {
+		t.Errorf("Expected T1's credentials to be removed, got %v (err: %v)", removed, err)
+	}
+	if app.workspaceFigure1("T1") != app.f1 {
+		t.Errorf("Expected T1 to go back to the default account")
+	}
+}
@@ -56,3 +56,36 @@ func TestCollectionIDParse(t *testing.T) {
 		}
 	}
 }
+
+func TestFig1LinkParse(t *testing.T) {
+	valid := map[string]string{
+		"https://app.figure1.com/?image=59076d6324d11b594b2dff1d&t=0":             contentCase,
+		"https://app.figure1.com/rd/image?imageid=59076d6324d11b594b2dff1d":       contentCase,
+		"https://app.figure1.com/images/59076d6324d11b594b2dff1d?imageType=0&t=0": contentCase,
+		"https://app.figure1.com/rd/publicprofile?username=ccovic":                contentUser,
+		"https://app.figure1.com/user/richardpenner":                              contentUser,
+		"https://app.figure1.com/rd/collections?id=5907549889c89eef5b1b3511":      contentCollection,
+		"https://app.figure1.com/collections/5907549889c89eef5b1b3511":            contentCollection,
+		"https://figure1.com:443/user/richardpenner":                              contentUser,
+	}
+	for str, expected := range valid {
+		kind, id := parseFig1Link(str)
+		if kind != expected || id == "" {
+			t.Errorf("Expected link \"%v\" to be a %v, got \"%v\" (id: %v)", str, expected, kind, id)
+		}
+	}
+
+	invalid := []string{
+		"59076d6324d11b594b2dff1d",
+		"https://app.figure1.com/about",
+		"https://example.com/images/59076d6324d11b594b2dff1d",
+		"https://evilfigure1.com/images/59076d6324d11b594b2dff1d",
+		"https://app.figure1.com.example.com/images/59076d6324d11b594b2dff1d",
+		"https://example.com/images/59076d6324d11b594b2dff1d?u=app.figure1.com",
+	}
+	for _, str := range invalid {
+		if kind, _ := parseFig1Link(str); kind != "" {
+			t.Errorf("Expected link \"%v\" to be invalid", str)
+		}
+	}
+}
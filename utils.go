@@ -1,14 +1,10 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 )
 
 func getCaseID(text string) (id string) {
@@ -18,7 +14,7 @@ func getCaseID(text string) (id string) {
 		u, err := url.Parse(text)
 
 		if err != nil || u.Host == "" {
-			fmt.Println("Failed to parse case url: ", text)
+			logger.Debug("Failed to parse case url", "text", text)
 			return ""
 		}
 
@@ -76,7 +72,7 @@ func getCollectionID(text string) (id string) {
 		u, err := url.Parse(text)
 
 		if err != nil || u.Host == "" {
-			fmt.Println("Failed to parse collection url: ", text)
+			logger.Debug("Failed to parse collection url", "text", text)
 			return ""
 		}
 
@@ -99,6 +95,36 @@ func getCollectionID(text string) (id string) {
 	return id
 }
 
+const (
+	contentCase       = "case"
+	contentUser       = "user"
+	contentCollection = "collection"
+)
+
+// parseFig1Link works out what kind of content a figure 1 url points to, and its id/username
+func parseFig1Link(text string) (kind, id string) {
+	u, err := url.Parse(text)
+	if err != nil || (u.Hostname() != "figure1.com" && !strings.HasSuffix(u.Hostname(), ".figure1.com")) {
+		return "", ""
+	}
+
+	query := u.Query()
+	path := u.EscapedPath()
+	switch {
+	case query.Get("imageid") != "" || query.Get("image") != "" || strings.HasPrefix(path, "/images/"):
+		kind, id = contentCase, getCaseID(text)
+	case strings.HasPrefix(path, "/rd/publicprofile") || strings.HasPrefix(path, "/user/"):
+		kind, id = contentUser, getUsername(text)
+	case strings.HasPrefix(path, "/rd/collections") || strings.HasPrefix(path, "/collections/"):
+		kind, id = contentCollection, getCollectionID(text)
+	}
+
+	if id == "" {
+		return "", ""
+	}
+	return kind, id
+}
+
 func truncateString(text string) string {
 	split := strings.Split(text, " ")
 	limit := 36
@@ -118,9 +144,9 @@ type requestError struct {
 	Err        error
 }
 
-func (err *requestError) handleError(res http.ResponseWriter) {
+func (err *requestError) handleError(ctx context.Context, res http.ResponseWriter) {
 	// log
-	logErr("%v %v", err.Msg, err.Err)
+	loggerFrom(ctx).Error(err.Msg, "err", err.Err)
 
 	// send response to slack
 	if err.ClientResp == "" {
@@ -135,56 +161,31 @@ type slackError struct {
 	Err        error
 }
 
-type slackErrorRequestBody struct {
-	ResponseType string `json:"response_type"`
-	Text         string `json:"text,omitempty"`
+func (se *slackError) handleError(ctx context.Context, link string) {
+	se.post(ctx, link, false)
+}
+
+// handleCommandError is handleError for slash commands, the error replaces the loading message
+func (se *slackError) handleCommandError(ctx context.Context, link string) {
+	se.post(ctx, link, true)
 }
 
-func (se *slackError) handleError(link string) {
+func (se *slackError) post(ctx context.Context, link string, replace bool) {
 	// log
-	logErr("%v %v", se.Msg, se.Err)
+	log := loggerFrom(ctx)
+	log.Error(se.Msg, "err", se.Err)
 
 	// post error to `response_url`
 	if se.ClientResp == "" {
 		se.ClientResp = "Error fetching content"
 	}
 
-	body := &slackErrorRequestBody{
-		Text:         se.ClientResp,
-		ResponseType: "ephemeral",
-	}
-	reqBody := new(bytes.Buffer)
-	if err := json.NewEncoder(reqBody).Encode(body); err != nil {
-		logErr("Error marshaling slack error body: %v", err)
-		return
-	}
-
-	req, err := http.NewRequest("POST", link, reqBody)
-	if err != nil {
-		logErr("Error creating slack error request: %v", err)
-		return
+	body := &SlackResponse{
+		Text:            se.ClientResp,
+		ResponseType:    "ephemeral",
+		ReplaceOriginal: replace,
 	}
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		logErr("Error making slack error request: %v", err)
-		return
+	if err := postToResponseURL(ctx, link, body); err != nil {
+		log.Error("Failed to post slack error", "err", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		logErr("Slack error request was not OK (status %v): %v", resp.StatusCode, string(data))
-		return
-	}
-}
-
-func logErr(format string, a ...interface{}) {
-	now := time.Now()
-	timestamp := now.Format(time.RFC822)
-
-	vals := append([]interface{}{timestamp}, a...)
-	str := "%v: " + format
-	fmt.Println(fmt.Sprintf(str, vals...))
 }
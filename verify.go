@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	slackSignatureVersion = "v0"
+	// slack recommends rejecting anything older than 5 minutes to prevent replays
+	maxRequestAge = 5 * time.Minute
+	// slash command and interaction payloads are tiny, anything bigger isn't from slack
+	maxRequestBodySize = 1 << 20
+)
+
+var (
+	errMissingSignature = errors.New("missing slack signature headers")
+	errInvalidTimestamp = errors.New("invalid slack request timestamp")
+	errStaleTimestamp   = errors.New("slack request timestamp is too old")
+	errBadSignature     = errors.New("slack signature did not match")
+)
+
+// verifySlackRequest is middleware that rejects any request that wasn't sent by slack.
+// Requests are checked against the signing secret, falling back to the legacy
+// verification token only if `allow_legacy_token` is set in conf.json.
+func (app *SlackApp) verifySlackRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(res, req.Body, maxRequestBodySize))
+		if err != nil {
+			msg := "Failed to read body"
+			(&requestError{msg, msg, err}).handleError(req.Context(), res)
+			return
+		}
+		req.Body.Close()
+
+		// handlers need to be able to read the body again
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		err = verifySignature(app.SigningSecret, req.Header, body, time.Now())
+		if err == errMissingSignature && app.AllowLegacyToken {
+			err = verifyLegacyToken(app.VerificationToken, req.Header, body)
+		}
+		if err != nil {
+			loggerFrom(req.Context()).Warn("Rejected unverified request", "err", err)
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// verifySignature checks the `X-Slack-Signature` header against the HMAC-SHA256
+// of the raw body, see https://api.slack.com/authentication/verifying-requests-from-slack
+func verifySignature(secret string, header http.Header, body []byte, now time.Time) error {
+	signature := header.Get("X-Slack-Signature")
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	if secret == "" || signature == "" || timestamp == "" {
+		return errMissingSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errInvalidTimestamp
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age < 0 {
+		age = -age
+	}
+	if age > maxRequestAge {
+		return errStaleTimestamp
+	}
+
+	expected := computeSignature(secret, timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errBadSignature
+	}
+	return nil
+}
+
+func computeSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(slackSignatureVersion + ":" + timestamp + ":"))
+	mac.Write(body)
+	return slackSignatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyLegacyToken compares the deprecated `token` field sent with every request,
+// which is either form encoded (slash commands), json (events) or json in a
+// form encoded `payload` (interactions)
+func verifyLegacyToken(expected string, header http.Header, body []byte) error {
+	if expected == "" {
+		return errMissingSignature
+	}
+
+	jsonBody := body
+	var token string
+	if !strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return err
+		}
+		token = values.Get("token")
+		jsonBody = []byte(values.Get("payload"))
+	}
+	if len(jsonBody) > 0 && token == "" {
+		var payload struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(jsonBody, &payload); err != nil {
+			return err
+		}
+		token = payload.Token
+	}
+
+	if !hmac.Equal([]byte(token), []byte(expected)) {
+		return errors.New("token provided did not match")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+func signedRequest(body, secret string, ts time.Time) *http.Request {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	req := httptest.NewRequest("POST", "/case", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", computeSignature(secret, timestamp, []byte(body)))
+	return req
+}
+
+func TestVerifySlackRequest(t *testing.T) {
+	app := &SlackApp{SigningSecret: testSigningSecret, VerificationToken: "legacy"}
+	body := "token=legacy&channel_id=C1&user_name=bob&text=59076d6324d11b594b2dff1d"
+
+	tampered := signedRequest(body, testSigningSecret, time.Now())
+	tampered.Body = ioutil.NopCloser(strings.NewReader(body + "&extra=1"))
+
+	wrongSecret := signedRequest(body, "not-the-secret", time.Now())
+
+	unsigned := httptest.NewRequest("POST", "/case", strings.NewReader(body))
+
+	cases := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"valid", signedRequest(body, testSigningSecret, time.Now()), http.StatusOK},
+		{"tampered body", tampered, http.StatusUnauthorized},
+		{"wrong secret", wrongSecret, http.StatusUnauthorized},
+		{"expired", signedRequest(body, testSigningSecret, time.Now().Add(-10*time.Minute)), http.StatusUnauthorized},
+		{"future", signedRequest(body, testSigningSecret, time.Now().Add(10*time.Minute)), http.StatusUnauthorized},
+		{"unsigned without legacy fallback", unsigned, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		var received string
+		handler := app.verifySlackRequest(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			req.ParseForm()
+			received = req.FormValue("text")
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, c.req)
+
+		if rec.Code != c.status {
+			t.Errorf("%v: expected status %v, got %v", c.name, c.status, rec.Code)
+		}
+		if c.status == http.StatusOK && received == "" {
+			t.Errorf("%v: expected handler to be able to read the body", c.name)
+		}
+	}
+}
+
+func TestVerifyLegacyTokenFallback(t *testing.T) {
+	app := &SlackApp{SigningSecret: testSigningSecret, VerificationToken: "legacy", AllowLegacyToken: true}
+	handler := app.verifySlackRequest(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}))
+
+	cases := map[string]int{
+		"token=legacy&text=abc": http.StatusOK,
+		"token=wrong&text=abc":  http.StatusUnauthorized,
+		"text=abc":              http.StatusUnauthorized,
+		// interactions send the token in their json payload
+		"payload=" + url.QueryEscape(`{"type":"block_actions","token":"legacy"}`): http.StatusOK,
+		"payload=" + url.QueryEscape(`{"type":"block_actions","token":"wrong"}`):  http.StatusUnauthorized,
+		"payload=" + url.QueryEscape(`{"type":"block_actions"`):                   http.StatusUnauthorized,
+	}
+	for body, status := range cases {
+		req := httptest.NewRequest("POST", "/case", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("Expected legacy body \"%v\" to get status %v, got %v", body, status, rec.Code)
+		}
+	}
+
+	// a bad signature must not fall back to the legacy token
+	req := signedRequest("token=legacy", "not-the-secret", time.Now())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected bad signature to be rejected even with a valid legacy token, got %v", rec.Code)
+	}
+}
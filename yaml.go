@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the subset of yaml that config files need: comments and
+// nested maps of scalars. Lists, anchors and multi-line strings aren't supported.
+// The result can be encoded as json and decoded into the config.
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	type level struct {
+		indent      int
+		childIndent int
+		values      map[string]interface{}
+	}
+	root := map[string]interface{}{}
+	stack := []*level{{indent: -1, childIndent: -1, values: root}}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		lineNum := i + 1
+		line := strings.TrimRight(stripYAMLComment(raw), " \r")
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %v: tabs can't be used for indentation", lineNum)
+		}
+		if strings.HasPrefix(content, "- ") || content == "-" {
+			return nil, fmt.Errorf("line %v: lists aren't supported", lineNum)
+		}
+
+		for indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		if parent.childIndent == -1 {
+			parent.childIndent = indent
+		} else if indent != parent.childIndent {
+			return nil, fmt.Errorf("line %v: unexpected indentation", lineNum)
+		}
+
+		key, value, err := splitYAMLPair(content)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNum, err)
+		}
+		if _, ok := parent.values[key]; ok {
+			return nil, fmt.Errorf("line %v: duplicate key %q", lineNum, key)
+		}
+
+		if value == "" {
+			child := map[string]interface{}{}
+			parent.values[key] = child
+			stack = append(stack, &level{indent: indent, childIndent: -1, values: child})
+			continue
+		}
+
+		scalar, err := parseYAMLScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNum, err)
+		}
+		parent.values[key] = scalar
+	}
+
+	return root, nil
+}
+
+// stripYAMLComment removes a `#` comment that isn't inside quotes
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func splitYAMLPair(content string) (key, value string, err error) {
+	var sep int
+	switch {
+	case strings.HasSuffix(content, ":"):
+		sep = len(content) - 1
+	case strings.Contains(content, ": "):
+		sep = strings.Index(content, ": ")
+	default:
+		return "", "", fmt.Errorf("expected `key: value`, got %q", content)
+	}
+
+	key = strings.TrimSpace(content[:sep])
+	value = strings.TrimSpace(content[sep+1:])
+	if unquoted, err := strconv.Unquote(key); err == nil {
+		key = unquoted
+	} else if len(key) >= 2 && key[0] == '\'' && key[len(key)-1] == '\'' {
+		key = key[1 : len(key)-1]
+	}
+	if key == "" {
+		return "", "", fmt.Errorf("missing key")
+	}
+	return key, value, nil
+}
+
+func parseYAMLScalar(value string) (interface{}, error) {
+	switch {
+	case value[0] == '"':
+		return strconv.Unquote(value)
+	case value[0] == '\'':
+		if len(value) < 2 || value[len(value)-1] != '\'' {
+			return nil, fmt.Errorf("unterminated string %v", value)
+		}
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1), nil
+	case value == "{}":
+		return map[string]interface{}{}, nil
+	case value[0] == '{' || value[0] == '[' || value[0] == '&' || value[0] == '*' || value[0] == '|' || value[0] == '>':
+		return nil, fmt.Errorf("unsupported value %v", value)
+	case value == "~" || value == "null":
+		return nil, nil
+	case value == "true":
+		return true, nil
+	case value == "false":
+		return false, nil
+	}
+
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f, nil
+	}
+	return value, nil
+}